@@ -0,0 +1,100 @@
+/**
+ * Audit Log - Append-only JSON-lines record of connections, tool calls, approvals and policy denials
+ */
+import { appendFileSync, readFileSync, renameSync, writeFileSync } from 'fs';
+import { userInfo } from 'os';
+
+export class AuditLog {
+  constructor(path = process.env.TMUX_MCP_AUDIT_LOG) {
+    this.path = path || null;
+    this.user = null;
+  }
+
+  isEnabled() {
+    return this.path !== null;
+  }
+
+  /**
+   * Append one event. `client` identifies the MCP client; over stdio the
+   * "source" is the parent process rather than an address.
+   */
+  record(event, client, details = {}) {
+    if (!this.isEnabled()) {
+      return;
+    }
+
+    if (this.user === null) {
+      try {
+        this.user = userInfo().username;
+      } catch (error) {
+        this.user = String(process.getuid?.() ?? 'unknown');
+      }
+    }
+
+    const entry = {
+      timestamp: new Date().toISOString(),
+      event,
+      client: client ? { name: client.name, version: client.version } : null,
+      source: { transport: 'stdio', parent_pid: process.ppid, user: this.user },
+      ...details
+    };
+    appendFileSync(this.path, JSON.stringify(entry) + '\n', { mode: 0o600 });
+  }
+
+  /**
+   * Replace every string field equal to `value` (e.g. a command that echoed a
+   * secret) with [REDACTED] in existing entries, marking each changed entry
+   * with `redacted: tombstone`. Returns how many entries changed.
+   */
+  scrub(value, tombstone) {
+    if (!this.isEnabled() || !value) {
+      return 0;
+    }
+
+    let text;
+    try {
+      text = readFileSync(this.path, 'utf-8');
+    } catch (error) {
+      if (error.code === 'ENOENT') {
+        return 0;
+      }
+      throw error;
+    }
+
+    let changed = 0;
+    const replace = item => {
+      if (item === value) {
+        return '[REDACTED]';
+      }
+      if (Array.isArray(item)) {
+        return item.map(replace);
+      }
+      if (item && typeof item === 'object') {
+        return Object.fromEntries(Object.entries(item).map(([key, nested]) => [key, replace(nested)]));
+      }
+      return item;
+    };
+
+    const lines = text.split('\n').filter(line => line).map(line => {
+      let entry;
+      try {
+        entry = JSON.parse(line);
+      } catch (error) {
+        return line;
+      }
+      const scrubbed = replace(entry);
+      if (JSON.stringify(scrubbed) === line) {
+        return line;
+      }
+      changed++;
+      return JSON.stringify({ ...scrubbed, redacted: tombstone });
+    });
+
+    if (changed > 0) {
+      const temporary = `${this.path}.tmp`;
+      writeFileSync(temporary, lines.map(line => line + '\n').join(''), { mode: 0o600 });
+      renameSync(temporary, this.path);
+    }
+    return changed;
+  }
+}
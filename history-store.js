@@ -0,0 +1,121 @@
+/**
+ * History Store - Append-only JSON-lines record of every finished command, for auditing
+ */
+import { appendFileSync, readFileSync, renameSync, writeFileSync } from 'fs';
+
+export class HistoryStore {
+  constructor(path = process.env.TMUX_MCP_HISTORY_FILE) {
+    this.path = path || null;
+  }
+
+  isEnabled() {
+    return this.path !== null;
+  }
+
+  /**
+   * Append one finished command; written synchronously so entries survive a crash
+   */
+  record(entry) {
+    if (!this.isEnabled()) {
+      return;
+    }
+    appendFileSync(this.path, JSON.stringify(entry) + '\n', { mode: 0o600 });
+  }
+
+  /**
+   * The recorded entry for a command ID, or null
+   */
+  find(id) {
+    return this.read().find(entry => entry.id === id) || null;
+  }
+
+  /**
+   * Newest-last entries matching the filters (all optional):
+   * status, branch, pane, contains (substring of the command), since (ISO or ms)
+   */
+  query({ status = null, branch = null, pane = null, contains = null, since = null, limit = 20 } = {}) {
+    const sinceTime = since !== null ? new Date(since).getTime() : null;
+    const entries = [];
+
+    for (const entry of this.read()) {
+      if (status && entry.status !== status) continue;
+      if (branch && entry.branch !== branch) continue;
+      if (pane !== null && String(entry.pane) !== String(pane)) continue;
+      if (contains && !entry.command.includes(contains)) continue;
+      if (sinceTime !== null && new Date(entry.started_at).getTime() < sinceTime) continue;
+      entries.push(entry);
+    }
+
+    return entries.slice(-limit);
+  }
+
+  /**
+   * Scrub one entry in place, leaving a tombstone ({ action, by, at, reason }).
+   * 'redact' keeps the metadata but drops the command text and output; 'delete'
+   * keeps only the ID and tombstone, and the entry no longer shows up in reads.
+   * Returns the entry as it was before, or null if there is no such entry.
+   */
+  redact(id, tombstone) {
+    let original = null;
+    const lines = this.lines().map(line => {
+      let entry;
+      try {
+        entry = JSON.parse(line);
+      } catch (error) {
+        return line;
+      }
+      if (entry.id !== id || entry.tombstone?.action === 'delete') {
+        return line;
+      }
+
+      original = entry;
+      const scrubbed = tombstone.action === 'delete' ?
+        { id, tombstone } :
+        { ...entry, command: '[REDACTED]', output: null, tombstone };
+      return JSON.stringify(scrubbed);
+    });
+
+    if (original) {
+      // Rewrite through a temporary file so a crash can't leave half the history behind
+      const temporary = `${this.path}.tmp`;
+      writeFileSync(temporary, lines.map(line => line + '\n').join(''), { mode: 0o600 });
+      renameSync(temporary, this.path);
+    }
+    return original;
+  }
+
+  /**
+   * All parseable entries, oldest first, without deleted ones
+   */
+  read() {
+    const entries = [];
+    for (const line of this.lines()) {
+      try {
+        const entry = JSON.parse(line);
+        if (entry.tombstone?.action !== 'delete') {
+          entries.push(entry);
+        }
+      } catch (error) {
+        // Torn final line from a crash mid-write
+      }
+    }
+    return entries;
+  }
+
+  lines() {
+    if (!this.isEnabled()) {
+      return [];
+    }
+
+    let text;
+    try {
+      text = readFileSync(this.path, 'utf-8');
+    } catch (error) {
+      if (error.code === 'ENOENT') {
+        return [];
+      }
+      throw error;
+    }
+    return text.split('\n').filter(line => line);
+  }
+}
@@ -0,0 +1,40 @@
+/**
+ * Keys Journal - Write-ahead log of every send-keys, flushed to disk before the keys are sent
+ */
+import { closeSync, fsyncSync, openSync, writeSync } from 'fs';
+
+export class KeysJournal {
+  constructor(path = process.env.TMUX_MCP_KEYS_JOURNAL) {
+    this.path = path || null;
+    this.fd = null;
+  }
+
+  isEnabled() {
+    return this.path !== null;
+  }
+
+  /**
+   * Append one entry and fsync it. Synchronous on purpose: the entry must be
+   * on disk before tmux receives the keys, so a crash can't lose it.
+   */
+  record(target, keys) {
+    if (!this.isEnabled()) {
+      return;
+    }
+
+    if (this.fd === null) {
+      this.fd = openSync(this.path, 'a', 0o600);
+    }
+
+    const entry = JSON.stringify({ timestamp: new Date().toISOString(), target, keys });
+    writeSync(this.fd, entry + '\n');
+    fsyncSync(this.fd);
+  }
+
+  close() {
+    if (this.fd !== null) {
+      closeSync(this.fd);
+      this.fd = null;
+    }
+  }
+}
@@ -15,25 +15,87 @@ import {
 import { TmuxManager } from './tmux-manager.js';
 import { CommandDetector } from './command-detector.js';
 import { HelpLoader } from './help-loader.js';
+import { ReceiptSigner } from './receipt-signer.js';
+import { WorkspaceTree } from './workspace-tree.js';
+import { RunawayDetector } from './runaway-detector.js';
+import { SecurityAudit } from './security-audit.js';
+import { OutputAssertions } from './output-assertions.js';
+import { ScratchDirectory } from './scratch-dir.js';
+import { PaneQueue } from './pane-queue.js';
+import { OutputBudget } from './output-budget.js';
+import { HistoryStore } from './history-store.js';
+import { SecretRedactor } from './secret-redactor.js';
+import { RateLimiter } from './rate-limiter.js';
+import { AuditLog } from './audit-log.js';
+import { PaneWatcher } from './pane-watcher.js';
+import { RetryDetector } from './retry-detector.js';
+import { summarizeUsage } from './usage-stats.js';
 import { v4 as uuidv4 } from 'uuid';
-
-class TmuxTerminalMCP {
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { realpathSync } from 'fs';
+import { fileURLToPath } from 'url';
+
+// Tools that type caller-composed text into a shell; rate limited, and TMUX_MCP_DISABLE_EXECUTE turns them off
+const FREE_FORM_TOOLS = new Set([
+  'execute_terminal_command',
+  'send_command_input',
+  'send_keys',
+  'send_pager_keys',
+  'broadcast_input'
+]);
+
+// Tools that start new commands; refused while shutting down
+const COMMAND_TOOLS = new Set([
+  'execute_terminal_command',
+  'broadcast_input'
+]);
+
+export class TmuxTerminalMCP {
   constructor() {
     this.server = new Server({
       name: 'tmux-terminal-mcp',
       version: '1.0.0',
     }, {
       capabilities: {
-        tools: {}
+        tools: {},
+        logging: {}
       }
     });
 
     this.tmux = new TmuxManager();
     this.detector = new CommandDetector();
     this.helpLoader = new HelpLoader();
+    this.signer = new ReceiptSigner();
+    this.workspaceTree = new WorkspaceTree();
+    this.runawayDetector = new RunawayDetector();
+    this.assertions = new OutputAssertions();
+    this.scratch = new ScratchDirectory();
+    this.paneQueue = new PaneQueue();
+    this.outputBudget = new OutputBudget();
+    this.history = new HistoryStore();
+    this.redactor = new SecretRedactor();
+    this.rateLimiter = new RateLimiter();
+    this.auditLog = new AuditLog();
+    this.retryDetector = new RetryDetector();
+    this.watcher = new PaneWatcher(
+      (paneId, scrollbackLines) => this.tmux.capturePane(paneId, scrollbackLines),
+      event => this.notifyTrigger(event),
+      paneId => this.tmux.getHistoryInfo(paneId)
+    );
+    this.autoCreate = ['1', 'true'].includes(process.env.TMUX_MCP_AUTO_CREATE);
+    this.strictCompletion = ['1', 'true'].includes(process.env.TMUX_MCP_STRICT_COMPLETION);
+    this.preserveAnsi = ['1', 'true'].includes(process.env.TMUX_MCP_PRESERVE_ANSI);
+    this.executeDisabled = ['1', 'true'].includes(process.env.TMUX_MCP_DISABLE_EXECUTE);
+    this.drainTimeout = parseInt(process.env.TMUX_MCP_DRAIN_TIMEOUT, 10) || 30;
+    this.requireApproval = ['1', 'true'].includes(process.env.TMUX_MCP_REQUIRE_APPROVAL);
+    this.approvalTimeout = parseInt(process.env.TMUX_MCP_APPROVAL_TIMEOUT, 10) || 60;
+    this.shuttingDown = false;
     this.isInitialized = false;
     this.helpShown = false;
     this.activeCommands = new Map();
+    // Commands a tool call is still waiting on synchronously (not in activeCommands)
+    this.pendingWaits = new Map();
 
     this.setupToolHandlers();
     this.setupRequestHandlers();
@@ -41,7 +103,7 @@ class TmuxTerminalMCP {
 
   setupRequestHandlers() {
     this.server.setRequestHandler(ListToolsRequestSchema, async () => ({
-      tools: [
+      tools: this.availableTools([
         {
           name: 'execute_terminal_command',
           description: 'Execute a command in the Claude Terminal (CT Pane) with intelligent timeout handling',
@@ -61,6 +123,86 @@ class TmuxTerminalMCP {
                 type: 'number',
                 description: 'Target pane number (1=right1, 2=right2, etc.). If not specified, uses default CT Pane.',
                 minimum: 1
+              },
+              complete_when: {
+                type: 'object',
+                description: 'Treat the command as complete once its output matches a readiness pattern (e.g. dev servers). The process keeps running in background mode.',
+                properties: {
+                  regex: {
+                    type: 'string',
+                    description: 'Regular expression matched against the pane output (e.g. "Server started on")'
+                  },
+                  consume: {
+                    type: 'boolean',
+                    description: 'Report the readiness match as the final result instead of pointing at background monitoring. The pane stays reserved until the process exits or cancel_command stops it (default: false)',
+                    default: false
+                  },
+                  timeout: {
+                    type: 'number',
+                    description: 'Seconds to wait for the readiness pattern before switching to background monitoring (default: 30)',
+                    default: 30
+                  }
+                },
+                required: ['regex']
+              },
+              persistence: {
+                type: 'string',
+                description: 'How much of the result is retained for get_command_status: full (default), metadata_only (no output), or ephemeral (output dropped once read)',
+                enum: ['full', 'metadata_only', 'ephemeral'],
+                default: 'full'
+              },
+              detect_exit_code: {
+                type: 'boolean',
+                description: 'Append a completion marker to the command so completion is detected deterministically and the exit code is reported (POSIX shells)',
+                default: false
+              },
+              strict_completion: {
+                type: 'boolean',
+                description: 'Only report completion on the deterministic completion marker, never on heuristics; rejected up front if the pane shell cannot support it (default: TMUX_MCP_STRICT_COMPLETION)'
+              },
+              pane_width: {
+                type: 'number',
+                description: 'Resize the pane to this many columns while the command runs, so output layout doesn\'t depend on the user\'s terminal size (limited to the window width)',
+                minimum: 20
+              },
+              preserve_ansi: {
+                type: 'boolean',
+                description: 'Deliver the final output with color/formatting escape sequences intact, for clients that render them (default: TMUX_MCP_PRESERVE_ANSI, otherwise plain text)'
+              },
+              fresh_pane: {
+                type: 'boolean',
+                description: 'Run the command in a new pane that is closed once the command finishes, keeping the CT Pane clean and isolating concurrent commands',
+                default: false
+              },
+              track_env: {
+                type: 'boolean',
+                description: 'Snapshot the shell environment before and after the command and report what changed (useful for `source`d scripts)',
+                default: false
+              },
+              max_tokens: {
+                type: 'number',
+                description: 'Trim the returned output to this many tokens (keeps the last lines) and report the delivered token count',
+                minimum: 1
+              },
+              expect: {
+                type: 'object',
+                description: 'Assertions evaluated on the result; the command is reported as assertion_failed when any is violated',
+                properties: {
+                  contains: {
+                    type: ['string', 'array'],
+                    items: { type: 'string' },
+                    description: 'Text (or list of texts) the output must contain'
+                  },
+                  not_contains: {
+                    type: ['string', 'array'],
+                    items: { type: 'string' },
+                    description: 'Text (or list of texts) the output must not contain'
+                  },
+                  exit_code: {
+                    type: 'number',
+                    description: 'Expected exit code (enables detect_exit_code)'
+                  }
+                }
               }
             },
             required: ['command']
@@ -95,15 +237,63 @@ class TmuxTerminalMCP {
         },
         {
           name: 'get_command_status',
-          description: 'Check the status of running commands',
+          description: 'Check the status of a command by ID (running, queued or finished), or list tracked commands',
           inputSchema: {
             type: 'object',
             properties: {
               command_id: {
                 type: 'string',
                 description: 'Specific command ID to check (optional)',
+              },
+              branch: {
+                type: 'string',
+                description: 'When listing, only show commands run on this git branch'
+              },
+              delta: {
+                type: 'boolean',
+                description: 'Return only output lines that appeared since the last delta check, with a sequence number (requires command_id)',
+                default: false
+              }
+            },
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'cancel_command',
+          description: 'Cancel a running background command by sending Ctrl+C to its pane and stopping its monitoring',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              command_id: {
+                type: 'string',
+                description: 'ID of the command to cancel'
+              }
+            },
+            required: ['command_id'],
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'send_command_input',
+          description: 'Send input to a running command (answer a y/n prompt, type into a REPL); monitoring continues afterwards',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              command_id: {
+                type: 'string',
+                description: 'ID of the running command'
+              },
+              input: {
+                type: 'string',
+                description: 'Text to type into the command'
+              },
+              press_enter: {
+                type: 'boolean',
+                description: 'Press Enter after the input (default: true)',
+                default: true
               }
             },
+            required: ['command_id', 'input'],
             additionalProperties: false
           }
         },
@@ -191,363 +381,2505 @@ class TmuxTerminalMCP {
             required: ['keys'],
             additionalProperties: false
           }
-        }
-      ]
-    }));
-
-    this.server.setRequestHandler(CallToolRequestSchema, async (request) => {
-      const { name, arguments: args } = request.params;
-
-      try {
-        // Show help automatically on first tool use (except get_terminal_help itself)
-        if (!this.helpShown && name !== 'get_terminal_help') {
-          this.helpShown = true;
-          const helpResult = await this.getTerminalHelp({ section: 'first-time' });
-          
-          // For the first tool call, prepend concise help to the actual result
-          const actualResult = await this.executeToolRequest(name, args);
-          
-          return {
-            content: [
-              ...helpResult.content,
-              {
-                type: 'text',
-                text: '\n' + '='.repeat(60) + '\n'
+        },
+        {
+          name: 'handoff_pane',
+          description: 'Hand a pane to the human and back. While the human controls it, agent commands to that pane are refused.',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              action: {
+                type: 'string',
+                description: 'request: ask the human to take over (tmux prompt); release: ask the human to hand the pane back (tmux prompt), or withdraw a pending request; status: show who controls the pane',
+                enum: ['request', 'release', 'status']
               },
-              ...actualResult.content
-            ]
-          };
-        }
-
-        return await this.executeToolRequest(name, args);
-      } catch (error) {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: `Error: ${error.message}`
-            }
-          ],
-          isError: true
-        };
-      }
-    });
-  }
-
-  async executeToolRequest(name, args) {
-    switch (name) {
-      case 'execute_terminal_command':
-        return await this.executeTerminalCommand(args);
-      case 'get_terminal_status':
-        return await this.getTerminalStatus();
-      case 'create_claude_terminal':
-        return await this.createClaudeTerminal();
-      case 'switch_terminal_focus':
-        return await this.switchTerminalFocus();
-      case 'get_command_status':
-        return await this.getCommandStatus(args);
-      case 'get_terminal_history':
-        return await this.getTerminalHistory(args);
-      case 'get_terminal_help':
-        return await this.getTerminalHelp(args);
-      case 'detect_pager':
-        return await this.detectPager(args);
-      case 'get_pager_info':
-        return await this.getPagerInfo(args);
-      case 'send_pager_keys':
-        return await this.sendPagerKeys(args);
-      default:
-        throw new Error(`Unknown tool: ${name}`);
-    }
-  }
-
-  setupToolHandlers() {
-    // Initialize on first tool call
-    this.ensureInitialized = async () => {
-      if (this.isInitialized) return;
-
-      console.error('🔍 Initializing Tmux Terminal MCP...');
-      
-      // Detect tmux environment
-      const env = await this.tmux.detectTmuxEnvironment();
-      if (!env.inTmux) {
-        throw new Error('❌ Not running in tmux session. Please start this MCP server from within tmux.');
-      }
-
-      console.error(`📍 Detected tmux session: ${env.session} (window ${env.window}, pane ${env.pane})`);
-
-      // Discover Claude Terminal and auto-configure if found
-      const discovery = await this.tmux.discoverClaudeTerminal();
-      
-      if (discovery.found) {
-        console.error(discovery.message);
-        // Sync directory to CT Pane
-        await this.tmux.syncDirectory();
-        if (discovery.assumed) {
-          console.error('💡 Tip: You can create a dedicated CT Pane with create_claude_terminal tool for better separation');
-        }
-      } else {
-        console.error(discovery.message);
-        console.error('💡 Use create_claude_terminal tool to create one, or I can suggest when to create it.');
-      }
-
-      // Show help guide for fresh Claude instances
-      console.error('📚 For usage guide, Claude will automatically show help on first interaction');
-
-      this.isInitialized = true;
-    };
-  }
-
-  /**
-   * Execute command with "Fire and Wait Briefly" strategy
-   */
-  async executeTerminalCommand({ command, wait_for_completion = null, target_pane = null }) {
-    await this.ensureInitialized();
-
-    const paneIndex = target_pane || this.tmux.ctPane;
-    if (!paneIndex) {
-      return {
-        content: [
-          {
-            type: 'text',
-            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+              target_pane: {
+                type: 'number',
+                description: 'Target pane number (1=right1, 2=right2, etc.). If not specified, uses default CT Pane.',
+                minimum: 1
+              }
+            },
+            required: ['action'],
+            additionalProperties: false
           }
-        ]
-      };
-    }
-
-    const commandId = uuidv4();
-    const analysis = this.detector.analyzeCommand(command);
-    const timeoutStrategy = this.detector.getTimeoutStrategy(command);
-    
-    console.error(`🚀 Executing: ${command}`);
-    console.error(`📊 Analysis: ${analysis.category}, estimated ${analysis.estimatedDuration}s`);
-
-    // Handle special cases first
-    if (analysis.special?.needsPasswordPrompt) {
-      await this.tmux.sendKeys(command, true);
-      await this.tmux.focusClaudeTerminal();
-      
-      return {
-        content: [
-          {
-            type: 'text',
-            text: `🔐 Sudo password required in Claude Terminal (pane ${this.tmux.ctPane}). Focus switched to CT Pane for password entry.`
+        },
+        {
+          name: 'manage_pane',
+          description: 'Provision the workspace: split a pane, kill a pane, resize it, or respawn its shell',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              action: {
+                type: 'string',
+                description: 'split: create a new pane next to the target; kill: close the target pane; resize: set its size; respawn: restart its shell (kills what is running)',
+                enum: ['split', 'kill', 'resize', 'respawn']
+              },
+              target_pane: {
+                type: 'number',
+                description: 'Target pane number (1=right1, 2=right2, etc.). If not specified, uses default CT Pane.',
+                minimum: 1
+              },
+              direction: {
+                type: 'string',
+                description: 'For split: horizontal (side by side, default) or vertical (stacked)',
+                enum: ['horizontal', 'vertical'],
+                default: 'horizontal'
+              },
+              size: {
+                type: 'number',
+                description: 'For split: size of the new pane in cells',
+                minimum: 1
+              },
+              width: {
+                type: 'number',
+                description: 'For resize: width in columns',
+                minimum: 1
+              },
+              height: {
+                type: 'number',
+                description: 'For resize: height in rows',
+                minimum: 1
+              }
+            },
+            required: ['action'],
+            additionalProperties: false
           }
-        ]
-      };
-    }
-
-    if (analysis.special?.editor || analysis.special?.repl || analysis.special?.monitor) {
-      await this.tmux.sendKeys(command, true);
-      await this.tmux.focusClaudeTerminal();
-      
-      return {
-        content: [
-          {
-            type: 'text',
-            text: `🎯 ${analysis.special.message}\n\nFocus switched to Claude Terminal (pane ${this.tmux.ctPane}).`
+        },
+        {
+          name: 'broadcast_input',
+          description: 'Run the same command in several panes at once (like synchronize-panes) and return each pane\'s output and exit code',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              command: {
+                type: 'string',
+                description: 'The command to run in every pane'
+              },
+              panes: {
+                type: 'array',
+                items: { type: 'number', minimum: 1 },
+                description: 'Pane numbers to send to'
+              },
+              group: {
+                type: 'string',
+                description: 'Send to every pane tagged with this group (tmux set-option -p @claude_group <name>)'
+              },
+              timeout: {
+                type: 'number',
+                description: 'Seconds to wait for all panes to finish before returning what they have so far (default: 30)',
+                default: 30
+              }
+            },
+            required: ['command'],
+            additionalProperties: false
           }
-        ]
-      };
-    }
-
-    // Clear pane and execute command
-    await this.tmux.clearPane(paneIndex);
-    await this.tmux.sendKeys(command, true, paneIndex);
-
-    // Implement "Fire and Wait Briefly" strategy
-    const shouldWaitForCompletion = wait_for_completion !== null ? 
-      wait_for_completion : 
-      timeoutStrategy.strategy !== 'async';
-
-    if (!shouldWaitForCompletion || timeoutStrategy.strategy === 'async') {
-      // Start async monitoring
-      this.monitorAsyncCommand(commandId, command, analysis);
+        },
+        {
+          name: 'send_keys',
+          description: 'Send raw keys to a pane without clearing it or pressing Enter (arrows, C-c, C-d, Escape, or literal text) for editors, pagers and interactive prompts',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              keys: {
+                type: ['string', 'array'],
+                items: { type: 'string' },
+                description: 'tmux key names (e.g. ["Up", "Enter"], "C-c", "Escape") or text when literal is true'
+              },
+              literal: {
+                type: 'boolean',
+                description: 'Send keys as literal text (tmux send-keys -l) instead of key names',
+                default: false
+              },
+              target_pane: {
+                type: 'number',
+                description: 'Target pane number (1=right1, 2=right2, etc.). If not specified, uses default CT Pane.',
+                minimum: 1
+              }
+            },
+            required: ['keys'],
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'verify_receipt',
+          description: 'Verify a signed command receipt produced by this server (requires TMUX_MCP_SIGNING_KEY)',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              receipt: {
+                type: 'string',
+                description: 'The receipt JSON as returned with the command result'
+              },
+              output: {
+                type: 'string',
+                description: 'Command output to check against the receipt hash (optional)'
+              }
+            },
+            required: ['receipt'],
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'get_workspace_tree',
+          description: 'Get a JSON file tree (with sizes and mtimes) of the project, sandboxed to the configured roots',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              path: {
+                type: 'string',
+                description: 'Directory to list, relative to the first root (default: the root itself)'
+              },
+              depth: {
+                type: 'number',
+                description: 'How many directory levels to descend (default: 2)',
+                default: 2,
+                minimum: 0
+              }
+            },
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'get_command_history',
+          description: 'Query the persisted history of finished commands (requires TMUX_MCP_HISTORY_FILE)',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              status: {
+                type: 'string',
+                description: 'Only commands that ended with this status',
+                enum: ['completed', 'failed', 'assertion_failed', 'interrupted', 'cancelled', 'error', 'interactive']
+              },
+              branch: {
+                type: 'string',
+                description: 'Only commands run on this git branch'
+              },
+              pane: {
+                type: 'string',
+                description: 'Only commands run in this pane'
+              },
+              contains: {
+                type: 'string',
+                description: 'Only commands whose text contains this substring'
+              },
+              since: {
+                type: 'string',
+                description: 'Only commands started at or after this ISO timestamp'
+              },
+              limit: {
+                type: 'number',
+                description: 'Maximum number of (most recent) entries to return (default: 20)',
+                default: 20,
+                minimum: 1
+              },
+              include_output: {
+                type: 'boolean',
+                description: 'Include each command\'s recorded output (default: false)',
+                default: false
+              }
+            },
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'redact_history',
+          description: 'Redact or delete one recorded command (e.g. one that echoed a secret) in the command history, scrubbing its command text from the audit log too. A tombstone records who did it, when and why (requires TMUX_MCP_HISTORY_FILE)',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              command_id: {
+                type: 'string',
+                description: 'ID of the recorded command'
+              },
+              action: {
+                type: 'string',
+                description: 'redact (default) keeps the entry\'s metadata but drops its command text and output; delete removes the entry from history queries, leaving only the tombstone',
+                enum: ['redact', 'delete'],
+                default: 'redact'
+              },
+              reason: {
+                type: 'string',
+                description: 'Why the entry is being removed; kept in the tombstone'
+              }
+            },
+            required: ['command_id', 'reason'],
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'get_usage_stats',
+          description: 'Summarize how the terminal has been used, from the persisted command history: commands per day, success and timeout rates, busiest panes, top commands and average duration (requires TMUX_MCP_HISTORY_FILE)',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              since: {
+                type: 'string',
+                description: 'Only count commands started at or after this ISO timestamp (default: all history)'
+              },
+              top: {
+                type: 'number',
+                description: 'How many panes and commands to list (default: 5)',
+                default: 5,
+                minimum: 1
+              }
+            },
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'snapshot_all_panes',
+          description: 'Capture every pane in the current window at once: its content, running program, who controls it and any tracked command',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              lines: {
+                type: 'number',
+                description: 'Keep only the last N lines of each pane (default: 20)',
+                default: 20,
+                minimum: 1
+              }
+            },
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'watch_pane',
+          description: 'Follow a pane\'s output without running anything in it (e.g. a build or server the user started): watch starts polling, read returns the lines that appeared since the last read, unwatch stops',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              action: {
+                type: 'string',
+                enum: ['watch', 'read', 'unwatch', 'list'],
+                description: 'watch: start following the pane; read: new lines since the last read; unwatch: stop; list: active watches'
+              },
+              target_pane: {
+                type: 'number',
+                description: 'Pane to watch (default: CT Pane)',
+                minimum: 1
+              },
+              interval: {
+                type: 'number',
+                description: 'Seconds between polls while watching (default: 2)',
+                default: 2,
+                minimum: 1
+              }
+            },
+            required: ['action'],
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'manage_trigger',
+          description: 'Alert on output patterns in a pane regardless of command boundaries (e.g. "panic:", "ERROR", "Compilation finished"). Adding a trigger starts watching the pane; matches are reported with context by watch_pane read and as trigger_fired log notifications.',
+          inputSchema: {
+            type: 'object',
+            properties: {
+              action: {
+                type: 'string',
+                enum: ['add', 'remove', 'list'],
+                description: 'add: register a trigger; remove: delete it by name; list: triggers and how often they fired'
+              },
+              target_pane: {
+                type: 'number',
+                description: 'Pane to watch (default: CT Pane)',
+                minimum: 1
+              },
+              name: {
+                type: 'string',
+                description: 'Trigger name (add/remove). Defaults to the pattern itself.'
+              },
+              pattern: {
+                type: 'string',
+                description: 'Regular expression matched against each new line (add)'
+              },
+              ignore_case: {
+                type: 'boolean',
+                description: 'Match case-insensitively (default: false)',
+                default: false
+              },
+              context_lines: {
+                type: 'number',
+                description: 'Lines of context to include either side of a match (default: 3)',
+                default: 3,
+                minimum: 0
+              }
+            },
+            required: ['action'],
+            additionalProperties: false
+          }
+        },
+        {
+          name: 'list_tmux_sessions',
+          description: 'List all tmux sessions, windows and panes with the command and PID running in each, to pick a target pane',
+          inputSchema: {
+            type: 'object',
+            properties: {},
+            additionalProperties: false
+          }
+        }
+      ])
+    }));
+
+    this.server.setRequestHandler(CallToolRequestSchema, async (request) => {
+      const { name, arguments: args } = request.params;
+      // Ephemeral commands must not outlive the call, not even in the audit log
+      const ephemeral = args?.persistence === 'ephemeral' && args.command !== undefined;
+      this.audit('tool_call', { tool: name, arguments: ephemeral ? { ...args, command: '[ephemeral command]' } : args ?? {} });
+
+      try {
+        // Show help automatically on first tool use (except get_terminal_help itself)
+        if (!this.helpShown && name !== 'get_terminal_help') {
+          this.helpShown = true;
+          const helpResult = await this.getTerminalHelp({ section: 'first-time' });
+          
+          // For the first tool call, prepend concise help to the actual result
+          const actualResult = await this.executeToolRequest(name, args);
+          
+          return this.redactResult({
+            content: [
+              ...helpResult.content,
+              {
+                type: 'text',
+                text: '\n' + '='.repeat(60) + '\n'
+              },
+              ...actualResult.content
+            ],
+            isError: actualResult.isError
+          });
+        }
+
+        return this.redactResult(await this.executeToolRequest(name, args));
+      } catch (error) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `Error: ${error.message}`
+            }
+          ],
+          isError: true
+        };
+      }
+    });
+  }
+
+  /**
+   * Append an event to the audit log (TMUX_MCP_AUDIT_LOG). Strings are redacted,
+   * and a log that can't be written never fails the tool call.
+   */
+  audit(event, details = {}) {
+    try {
+      this.auditLog.record(event, this.server.getClientVersion?.(), this.redactor.redactDeep(details));
+    } catch (error) {
+      console.error(`⚠️ Failed to write audit log: ${error.message}`);
+    }
+  }
+
+  /**
+   * Last line of defence: no tool result leaves the server with a recognised secret in it
+   */
+  redactResult(result) {
+    return {
+      ...result,
+      content: result.content.map(item => item.type === 'text' ? { ...item, text: this.redactor.redact(item.text) } : item)
+    };
+  }
+
+  /**
+   * Tool list as advertised to the client; least-privilege mode hides free-form input tools
+   */
+  availableTools(tools) {
+    return this.executeDisabled ? tools.filter(tool => !FREE_FORM_TOOLS.has(tool.name)) : tools;
+  }
+
+  async executeToolRequest(name, args) {
+    if (this.executeDisabled && FREE_FORM_TOOLS.has(name)) {
+      this.audit('policy_denial', { tool: name, policy: 'disable_execute' });
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `🔒 ${name} is disabled on this server (TMUX_MCP_DISABLE_EXECUTE). Only status, capture and structured pane tools are available.`
+          }
+        ],
+        isError: true
+      };
+    }
+
+    if (this.shuttingDown && COMMAND_TOOLS.has(name)) {
+      this.audit('policy_denial', { tool: name, policy: 'shutting_down' });
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `⏹️ Server is shutting down; ${name} is no longer accepting new commands.`
+          }
+        ],
+        isError: true
+      };
+    }
+
+    // Keys and input can run commands too, so they share the budget
+    if (FREE_FORM_TOOLS.has(name)) {
+      const { allowed, retryAfter } = this.rateLimiter.take();
+      if (!allowed) {
+        this.audit('policy_denial', { tool: name, policy: 'rate_limited', retry_after: retryAfter });
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `⏱️ rate_limited: more than ${this.rateLimiter.perMinute} commands or key sends per minute (burst ${this.rateLimiter.burst}). Retry in ${retryAfter}s.`
+            }
+          ],
+          isError: true
+        };
+      }
+    }
+
+    switch (name) {
+      case 'execute_terminal_command':
+        return await this.executeTerminalCommand(args);
+      case 'get_terminal_status':
+        return await this.getTerminalStatus();
+      case 'create_claude_terminal':
+        return await this.createClaudeTerminal();
+      case 'switch_terminal_focus':
+        return await this.switchTerminalFocus();
+      case 'get_command_status':
+        return await this.getCommandStatus(args);
+      case 'cancel_command':
+        return await this.cancelCommand(args);
+      case 'send_command_input':
+        return await this.sendCommandInput(args);
+      case 'get_terminal_history':
+        return await this.getTerminalHistory(args);
+      case 'get_terminal_help':
+        return await this.getTerminalHelp(args);
+      case 'detect_pager':
+        return await this.detectPager(args);
+      case 'get_pager_info':
+        return await this.getPagerInfo(args);
+      case 'send_pager_keys':
+        return await this.sendPagerKeys(args);
+      case 'handoff_pane':
+        return await this.handoffPane(args);
+      case 'manage_pane':
+        return await this.managePane(args);
+      case 'broadcast_input':
+        return await this.broadcastInput(args);
+      case 'send_keys':
+        return await this.sendKeys(args);
+      case 'verify_receipt':
+        return await this.verifyReceipt(args);
+      case 'get_workspace_tree':
+        return await this.getWorkspaceTree(args);
+      case 'get_command_history':
+        return await this.getCommandHistory(args);
+      case 'redact_history':
+        return await this.redactHistory(args);
+      case 'get_usage_stats':
+        return await this.getUsageStats(args);
+      case 'snapshot_all_panes':
+        return await this.snapshotAllPanes(args);
+      case 'watch_pane':
+        return await this.watchPane(args);
+      case 'manage_trigger':
+        return await this.manageTrigger(args);
+      case 'list_tmux_sessions':
+        return await this.listTmuxSessions();
+      default:
+        throw new Error(`Unknown tool: ${name}`);
+    }
+  }
+
+  setupToolHandlers() {
+    // Initialize on first tool call
+    this.ensureInitialized = async () => {
+      if (this.isInitialized) {
+        if (this.autoCreate) {
+          await this.respawnIfMissing();
+        }
+        return;
+      }
+
+      console.error('🔍 Initializing Tmux Terminal MCP...');
+      
+      // Detect tmux environment
+      const env = await this.tmux.detectTmuxEnvironment();
+      if (!env.inTmux) {
+        if (!this.autoCreate) {
+          throw new Error('❌ Not running in tmux session. Please start this MCP server from within tmux, or set TMUX_MCP_AUTO_CREATE=1.');
+        }
+        await this.createBridgeSession();
+      } else {
+        console.error(`📍 Detected tmux session: ${env.session} (window ${env.window}, pane ${env.pane})`);
+      }
+
+      // Discover Claude Terminal and auto-configure if found
+      const discovery = await this.tmux.discoverClaudeTerminal();
+      
+      if (discovery.found) {
+        console.error(discovery.message);
+        // Sync directory to CT Pane
+        await this.tmux.syncDirectory();
+        if (discovery.assumed) {
+          console.error('💡 Tip: You can create a dedicated CT Pane with create_claude_terminal tool for better separation');
+        }
+      } else if (this.autoCreate) {
+        const created = await this.tmux.createClaudeTerminal();
+        console.error(created.message);
+      } else {
+        console.error(discovery.message);
+        console.error('💡 Use create_claude_terminal tool to create one, or I can suggest when to create it.');
+      }
+
+      // Show help guide for fresh Claude instances
+      console.error('📚 For usage guide, Claude will automatically show help on first interaction');
+
+      this.isInitialized = true;
+    };
+  }
+
+  /**
+   * Create (or attach to) the TMUX_MCP_SESSION session when the server isn't inside tmux,
+   * running TMUX_MCP_INIT_COMMAND in it when the session is new
+   */
+  async createBridgeSession() {
+    const name = process.env.TMUX_MCP_SESSION || 'claude-bridge';
+    const created = await this.tmux.ensureSession(name);
+    console.error(created ? `🆕 Created tmux session ${name}` : `📍 Using existing tmux session ${name}`);
+
+    if (created && process.env.TMUX_MCP_INIT_COMMAND) {
+      await this.tmux.sendKeys(process.env.TMUX_MCP_INIT_COMMAND, true, this.tmux.currentPane);
+    }
+  }
+
+  /**
+   * Recreate the session and CT Pane if they died while the server was running
+   */
+  async respawnIfMissing() {
+    if (!await this.tmux.hasSession()) {
+      console.error(`⚠️ tmux session ${this.tmux.currentSession} is gone; recreating it`);
+      await this.createBridgeSession();
+      this.tmux.ctPane = null;
+    }
+
+    const panes = await this.tmux.listPanes();
+    if (this.tmux.ctPane && panes.some(pane => pane.index === this.tmux.ctPane)) {
+      return;
+    }
+
+    console.error('⚠️ Claude Terminal pane is gone; recreating it');
+    const created = await this.tmux.createClaudeTerminal();
+    console.error(created.message);
+  }
+
+  /**
+   * Execute command with "Fire and Wait Briefly" strategy
+   */
+  async executeTerminalCommand({ command, wait_for_completion = null, target_pane = null, complete_when = null, persistence = 'full', detect_exit_code = false, strict_completion = this.strictCompletion, fresh_pane = false, pane_width = null, preserve_ansi = this.preserveAnsi, track_env = false, expect = null, max_tokens = null }) {
+    await this.ensureInitialized();
+
+    let paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+          }
+        ]
+      };
+    }
+
+    const refusal = fresh_pane ? null : await this.checkAgentControl(paneIndex);
+    if (refusal) {
+      return refusal;
+    }
+
+    const commandId = uuidv4();
+    const analysis = this.detector.analyzeCommand(command);
+    const timeoutStrategy = this.detector.getTimeoutStrategy(command);
+
+    // Compile readiness pattern up front so a bad regex fails before anything is sent
+    let readiness = null;
+    if (complete_when?.regex) {
+      try {
+        readiness = {
+          pattern: new RegExp(complete_when.regex),
+          consume: complete_when.consume === true,
+          timeout: (complete_when.timeout || 30) * 1000
+        };
+      } catch (error) {
+        throw new Error(`Invalid complete_when regex: ${error.message}`);
+      }
+    }
+
+    const denial = await this.checkApproval(command, paneIndex, commandId, { ephemeral: persistence === 'ephemeral' });
+    if (denial) {
+      return denial;
+    }
+
+    // Ephemeral commands are never written to the server log either
+    console.error(`🚀 Executing: ${persistence === 'ephemeral' ? '[ephemeral command]' : this.redactor.redact(command)}`);
+    console.error(`📊 Analysis: ${analysis.category}, estimated ${analysis.estimatedDuration}s`);
+
+    // Interactive programs take over the pane, so they can't wait in or cut into its queue
+    if ((analysis.special?.needsPasswordPrompt || analysis.special?.editor || analysis.special?.repl || analysis.special?.monitor) &&
+        this.paneQueue.isBusy(paneIndex)) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `⏳ Pane ${paneIndex} is running a tracked command. Wait for it to finish, or cancel it with cancel_command, before starting ${command} there.`
+          }
+        ],
+        isError: true
+      };
+    }
+
+    // Handle special cases first
+    const journaled = persistence === 'ephemeral' ? '[ephemeral command]' : command;
+    if (analysis.special?.needsPasswordPrompt) {
+      await this.tmux.sendKeys(command, true, paneIndex, journaled);
+      await this.tmux.focusClaudeTerminal(paneIndex);
+      // Not tracked after this, so the history only shows it was handed to the user
+      this.recordHistory(commandId, command, { status: 'interactive', startedAt: Date.now(), persistence, execution: { paneIndex } });
+      
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `🔐 Sudo password required in pane ${paneIndex}. Focus switched there for password entry.`
+          }
+        ]
+      };
+    }
+
+    if (analysis.special?.editor || analysis.special?.repl || analysis.special?.monitor) {
+      await this.tmux.sendKeys(command, true, paneIndex, journaled);
+      await this.tmux.focusClaudeTerminal(paneIndex);
+      this.recordHistory(commandId, command, { status: 'interactive', startedAt: Date.now(), persistence, execution: { paneIndex } });
+      
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `🎯 ${analysis.special.message}\n\nFocus switched to pane ${paneIndex}.`
+          }
+        ]
+      };
+    }
+
+    // Run in a throwaway pane, addressed by pane ID so later layout changes can't retarget it
+    if (fresh_pane) {
+      paneIndex = await this.tmux.createFreshPane();
+      console.error(`🧪 Running in fresh pane ${paneIndex}`);
+    }
+
+    // Strict mode relies entirely on the sentinel, so refuse shells where it can't work
+    if (strict_completion) {
+      const shell = await this.tmux.getPaneShell(paneIndex);
+      if (!this.tmux.supportsSentinel(shell)) {
+        if (fresh_pane) {
+          await this.tmux.killPane(paneIndex);
+        }
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `🚫 strict_completion needs a POSIX shell in pane ${paneIndex}, but it is running ${shell}. ` +
+                    'Completion could only be detected heuristically there. Start bash/zsh/sh in the pane, or drop strict_completion.'
+            }
+          ],
+          isError: true
+        };
+      }
+    }
+
+    // Clear pane and execute command
+    const execution = {
+      paneIndex,
+      branch: await this.tmux.getPaneBranch(paneIndex),
+      freshPane: fresh_pane,
+      preserveAnsi: preserve_ansi,
+      paneWidth: pane_width,
+      sentinelId: strict_completion || detect_exit_code || this.assertions.needsExitCode(expect) ? commandId.slice(0, 8) : null,
+      snapshotBase: track_env ? join(tmpdir(), `bridge-env-${commandId.slice(0, 8)}`) : null,
+      runaway: {},
+      warnings: [],
+      expect,
+      maxTokens: max_tokens,
+      persistence
+    };
+
+    let wrappedCommand = command;
+    if (execution.snapshotBase) {
+      wrappedCommand = this.tmux.wrapWithEnvSnapshot(wrappedCommand, execution.snapshotBase);
+    }
+    if (execution.sentinelId) {
+      wrappedCommand = this.tmux.wrapWithSentinel(wrappedCommand, execution.sentinelId);
+    }
+    // Every command gets the conversation's scratch directory as $BRIDGE_SCRATCH
+    wrappedCommand = this.scratch.wrap(wrappedCommand);
+    execution.command = command;
+    execution.typedCommand = wrappedCommand;
+
+    // Nearly the same command failed a moment ago: say so before it fails again
+    const repeat = this.history.isEnabled() ? this.retryDetector.findRepeat(command, this.history.read()) : null;
+    if (repeat) {
+      this.flagRepeatedFailure(commandId, persistence === 'ephemeral' ? '[ephemeral command]' : command, repeat, execution);
+    }
+
+    // One command per pane at a time; later ones wait their turn in the background
+    if (this.paneQueue.isBusy(paneIndex)) {
+      const position = this.paneQueue.enqueue(paneIndex, commandId, () =>
+        this.startQueuedCommand(commandId, command, wrappedCommand, analysis, { persistence, execution })
+      );
+      this.activeCommands.set(commandId, {
+        command,
+        startTime: Date.now(),
+        analysis,
+        persistence,
+        paneIndex,
+        branch: execution.branch,
+        execution,
+        status: 'queued',
+        monitorTimer: null
+      });
+
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `⏳ ${command} queued at position ${position} for pane ${paneIndex} (another command is still running there).\n\nCommand ID: ${commandId}\nIt will run in the background when the pane is free. Use get_command_status to check progress.`
+          }
+        ]
+      };
+    }
+
+    this.paneQueue.acquire(paneIndex, commandId);
+    try {
+      await this.startCommand(paneIndex, wrappedCommand, execution);
+    } catch (error) {
+      this.releasePane(execution, commandId);
+      throw error;
+    }
+
+    // Implement "Fire and Wait Briefly" strategy
+    const shouldWaitForCompletion = wait_for_completion !== null ? 
+      wait_for_completion : 
+      (readiness !== null || timeoutStrategy.strategy !== 'async');
+
+    if (!shouldWaitForCompletion || (timeoutStrategy.strategy === 'async' && !readiness)) {
+      // Start async monitoring
+      this.monitorAsyncCommand(commandId, command, analysis, { persistence, execution });
+      
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `🔄 ${command} started in pane ${paneIndex}\n\n${timeoutStrategy.reason}\n\nCommand ID: ${commandId}\nUse get_command_status to check progress.`
+          }
+        ]
+      };
+    }
+
+    // Wait briefly for completion
+    this.pendingWaits.set(commandId, { command, paneIndex, startTime: Date.now(), persistence, execution });
+    let result;
+    try {
+      result = await this.waitForCommandCompletion(
+        commandId,
+        command,
+        readiness ? readiness.timeout : timeoutStrategy.timeout,
+        { readiness, persistence, execution }
+      );
+    } finally {
+      this.pendingWaits.delete(commandId);
+    }
+    
+    return {
+      content: [
+        {
+          type: 'text',
+          text: result
+        }
+      ]
+    };
+  }
+
+  /**
+   * Clear the pane and type the (wrapped) command
+   */
+  async startCommand(paneIndex, wrappedCommand, execution) {
+    // Pane indexes shift when panes are added or removed; the ID doesn't
+    execution.paneId = await this.tmux.getPaneId(paneIndex);
+
+    // Fixed geometry for programs that lay out output by terminal width
+    if (execution.paneWidth) {
+      execution.originalWidth = await this.tmux.getPaneWidth(paneIndex);
+      await this.tmux.resizePane(paneIndex, { width: execution.paneWidth });
+      const width = await this.tmux.getPaneWidth(paneIndex);
+      if (width < execution.paneWidth) {
+        execution.warnings.push(`Pane could only be widened to ${width} columns (window is narrower than ${execution.paneWidth})`);
+      }
+    }
+
+    await this.tmux.clearPane(paneIndex);
+
+    // Remember where the command starts in scrollback so long output isn't lost
+    execution.historyStart = (await this.tmux.getHistoryInfo(paneIndex)).historySize;
+
+    await this.tmux.sendKeys(wrappedCommand, true, paneIndex, execution.persistence === 'ephemeral' ? '[ephemeral command]' : wrappedCommand);
+  }
+
+  /**
+   * Start a command that waited in the pane queue; its caller is gone, so it runs in the background
+   */
+  async startQueuedCommand(commandId, command, wrappedCommand, analysis, { persistence, execution }) {
+    try {
+      if (await this.tmux.getPaneControl(execution.paneIndex) === 'human') {
+        throw new Error(`Pane ${execution.paneIndex} was handed to the user before the command could start`);
+      }
+      await this.startCommand(execution.paneIndex, wrappedCommand, execution);
+      const startTime = this.activeCommands.get(commandId)?.startTime;
+      this.monitorAsyncCommand(commandId, command, analysis, { persistence, execution, startTime });
+    } catch (error) {
+      const commandInfo = this.activeCommands.get(commandId);
+      if (commandInfo) {
+        commandInfo.status = 'error';
+        commandInfo.error = error.message;
+      }
+      this.releasePane(execution, commandId);
+    }
+  }
+
+  /**
+   * Wait for command completion with timeout
+   */
+  async waitForCommandCompletion(commandId, command, timeoutMs, { readiness = null, persistence = 'full', execution = {} } = {}) {
+    const startTime = Date.now();
+    const maxWaitTime = timeoutMs || 5000;
+    let lastOutput = '';
+
+    while (Date.now() - startTime < maxWaitTime) {
+      await new Promise(resolve => setTimeout(resolve, 500));
+      
+      try {
+        const { output, commandOutput, ansiOutput, exitCode, complete } = await this.captureCommandState(execution);
+        
+        if (complete) {
+          const duration = ((Date.now() - startTime) / 1000).toFixed(1);
+          const envDiff = execution.snapshotBase ? await this.tmux.readEnvSnapshotDiff(execution.snapshotBase) : undefined;
+          const envText = envDiff !== undefined ? `\n\n🌱 Environment changes:\n${this.tmux.formatEnvironmentDiff(envDiff)}` : '';
+          const { delivered, receipt, details } = this.formatDelivery(commandId, command, {
+            output: ansiOutput ?? output,
+            exitCode,
+            startTime,
+            execution,
+            envText
+          });
+          this.releasePane(execution, commandId);
+          const verdict = this.assertions.evaluate(execution.expect, { output: commandOutput, exitCode });
+          const status = !verdict.passed ? 'assertion_failed' : (exitCode !== null && exitCode !== 0 ? 'failed' : 'completed');
+          this.recordHistory(commandId, command, {
+            status,
+            output: delivered.text,
+            exitCode,
+            startedAt: startTime,
+            persistence,
+            execution
+          });
+          this.rememberResult(commandId, command, {
+            status,
+            output: delivered.text,
+            exitCode,
+            startTime,
+            persistence,
+            execution,
+            budgetNote: delivered.note,
+            assertionFailures: verdict.failures,
+            envDiff,
+            receipt
+          });
+          const idText = `\n\nCommand ID: ${commandId}`;
+          if (!verdict.passed) {
+            return `❌ ${command} assertion_failed in ${duration}s:\n${verdict.failures.map(failure => `- ${failure}`).join('\n')}\n\n${details}${idText}`;
+          }
+          if (exitCode !== null && exitCode !== 0) {
+            return `❌ ${command} failed in ${duration}s (exit code ${exitCode}):\n\n${details}${idText}`;
+          }
+          const exitText = exitCode !== null ? ` (exit code ${exitCode})` : '';
+          const assertionText = execution.expect ? ' (assertions passed)' : '';
+          return `✅ ${command} completed in ${duration}s${exitText}${assertionText}:\n\n${details}${idText}`;
+        }
+
+        // Runaway output: warn or interrupt per policy
+        const anomaly = await this.checkRunaway(execution, output);
+        if (anomaly?.policy === 'interrupt') {
+          const { delivered, receipt, details } = this.formatDelivery(commandId, command, { output: ansiOutput ?? output, startTime, execution });
+          this.releasePane(execution, commandId);
+          this.recordHistory(commandId, command, { status: 'interrupted', output: delivered.text, startedAt: startTime, persistence, execution });
+          this.rememberResult(commandId, command, {
+            status: 'interrupted',
+            output: delivered.text,
+            startTime,
+            persistence,
+            execution,
+            budgetNote: delivered.note,
+            receipt,
+            error: `Runaway output detected (${anomaly.reason})`
+          });
+          return `🛑 ${command} interrupted: runaway output detected (${anomaly.reason}).\n\n${details}\n\nCommand ID: ${commandId}`;
+        }
+
+        // Readiness sentinel: report complete while the process keeps running.
+        // The pane stays reserved until the process exits or is cancelled, so
+        // later commands queue or fail over instead of typing into it.
+        if (readiness && readiness.pattern.test(commandOutput)) {
+          const duration = ((Date.now() - startTime) / 1000).toFixed(1);
+          const { details } = this.formatDelivery(commandId, command, { output: ansiOutput ?? output, startTime, execution });
+          this.monitorAsyncCommand(commandId, command, this.detector.analyzeCommand(command), { persistence, execution, startTime });
+          this.activeCommands.get(commandId).status = 'ready';
+          const paneText = `pane ${execution.paneIndex} stays reserved until it exits (cancel_command stops it)`;
+          if (readiness.consume) {
+            return `✅ ${command} ready in ${duration}s (matched /${readiness.pattern.source}/); ${paneText}:\n\n${details}\n\nCommand ID: ${commandId}`;
+          }
+          return `✅ ${command} ready in ${duration}s (matched /${readiness.pattern.source}/), still running in background; ${paneText}:\n\n${details}\n\nCommand ID: ${commandId}\nUse get_command_status to check progress.`;
+        }
+
+        // Check for interactive prompts
+        if (this.tmux.detectInteractivePrompts(output) && output !== lastOutput) {
+          await this.tmux.focusClaudeTerminal(execution.paneIndex);
+
+          // Track the command so input can be sent with send_command_input
+          this.monitorAsyncCommand(commandId, command, this.detector.analyzeCommand(command), { persistence, execution, startTime });
+          const commandInfo = this.activeCommands.get(commandId);
+          commandInfo.status = 'needs_interaction';
+          this.retainOutput(commandInfo, output);
+
+          return `🔐 Interactive prompt detected in pane ${execution.paneIndex}. Focus switched for user input.\n\nCurrent output:\n${output}\n\nCommand ID: ${commandId}\nUse send_command_input to answer, or let the user type in the pane.`;
+        }
+
+        lastOutput = output;
+      } catch (error) {
+        this.releasePane(execution, commandId);
+        this.rememberResult(commandId, command, { status: 'error', startTime, persistence, execution, error: error.message });
+        return `❌ Error monitoring command: ${error.message}\n\nCommand ID: ${commandId}`;
+      }
+    }
+
+    // Timeout reached, switch to async monitoring
+    execution.timedOut = true;
+    this.monitorAsyncCommand(commandId, command, this.detector.analyzeCommand(command), { persistence, execution, startTime });
+    const duration = ((Date.now() - startTime) / 1000).toFixed(1);
+    
+    return `🔄 ${command} running longer than expected (${duration}s+). Switched to background monitoring.${this.formatWarnings(execution)}\n\nCommand ID: ${commandId}\nUse get_command_status to check progress.`;
+  }
+
+  /**
+   * Monitor long-running command asynchronously
+   */
+  async monitorAsyncCommand(commandId, command, analysis, { persistence = 'full', execution = {}, startTime = Date.now() } = {}) {
+    this.activeCommands.set(commandId, {
+      command,
+      startTime,
+      analysis,
+      persistence,
+      paneIndex: execution.paneIndex || this.tmux.ctPane,
+      branch: execution.branch || null,
+      execution,
+      status: 'running',
+      monitorTimer: null
+    });
+
+    // Poll every 10 seconds for completion
+    const monitor = async () => {
+      if (this.activeCommands.get(commandId)?.status === 'cancelled') {
+        return;
+      }
+
+      try {
+        const { output, commandOutput, ansiOutput, exitCode, complete } = await this.captureCommandState(execution);
+
+        // Cancelled while we were capturing
+        if (this.activeCommands.get(commandId)?.status === 'cancelled') {
+          return;
+        }
+        
+        if (complete) {
+          const commandInfo = this.activeCommands.get(commandId);
+          if (commandInfo) {
+            const duration = ((Date.now() - commandInfo.startTime) / 1000).toFixed(1);
+            const verdict = this.assertions.evaluate(execution.expect, { output: commandOutput, exitCode });
+            commandInfo.status = !verdict.passed ? 'assertion_failed' :
+              (exitCode !== null && exitCode !== 0 ? 'failed' : 'completed');
+            commandInfo.exitCode = exitCode;
+            commandInfo.assertionFailures = verdict.failures;
+            if (execution.snapshotBase) {
+              commandInfo.envDiff = await this.tmux.readEnvSnapshotDiff(execution.snapshotBase);
+            }
+            const delivered = this.applyBudget(ansiOutput ?? output, execution);
+            this.retainOutput(commandInfo, delivered.text);
+            commandInfo.budgetNote = delivered.note;
+            commandInfo.duration = duration;
+            commandInfo.receipt = this.signer.sign({
+              commandId,
+              command: this.redactor.redact(command),
+              output: delivered.text,
+              exitCode,
+              startedAt: commandInfo.startTime,
+              completedAt: Date.now()
+            });
+            
+            this.recordHistory(commandId, command, {
+              status: commandInfo.status,
+              output: delivered.text,
+              exitCode,
+              startedAt: commandInfo.startTime,
+              persistence,
+              execution
+            });
+            
+            console.error(`✅ Background command completed: ${commandInfo.persistence === 'ephemeral' ? '[ephemeral command]' : command} (${duration}s)`);
+          }
+          this.releasePane(execution, commandId);
+          return;
+        }
+
+        const anomaly = await this.checkRunaway(execution, output);
+        if (anomaly?.policy === 'interrupt') {
+          const commandInfo = this.activeCommands.get(commandId);
+          if (commandInfo) {
+            commandInfo.status = 'interrupted';
+            commandInfo.error = `Runaway output detected (${anomaly.reason})`;
+            const delivered = this.applyBudget(ansiOutput ?? output, execution);
+            this.retainOutput(commandInfo, delivered.text);
+            commandInfo.budgetNote = delivered.note;
+            this.recordHistory(commandId, command, { status: 'interrupted', output: delivered.text, startedAt: commandInfo.startTime, persistence, execution });
+          }
+          this.releasePane(execution, commandId);
+          return;
+        }
+
+        // Check for interactive prompts. Keep polling afterwards so the pane is
+        // released once the user (or send_command_input) answers and it finishes.
+        const commandInfo = this.activeCommands.get(commandId);
+        if (this.tmux.detectInteractivePrompts(output)) {
+          if (commandInfo && commandInfo.status !== 'needs_interaction') {
+            commandInfo.status = 'needs_interaction';
+            this.retainOutput(commandInfo, output);
+            
+            console.error(`🔐 Background command needs interaction: ${commandInfo.persistence === 'ephemeral' ? '[ephemeral command]' : command}`);
+            await this.tmux.focusClaudeTerminal(commandInfo.paneIndex);
+          }
+        } else if (commandInfo?.status === 'needs_interaction') {
+          commandInfo.status = 'running';
+        }
+
+        // Continue monitoring
+        this.scheduleMonitor(commandId, monitor, 10000);
+        
+      } catch (error) {
+        const commandInfo = this.activeCommands.get(commandId);
+        if (commandInfo) {
+          commandInfo.status = 'error';
+          commandInfo.error = error.message;
+          this.recordHistory(commandId, command, { status: 'error', startedAt: commandInfo.startTime, persistence, execution });
+        }
+        console.error(`❌ Error monitoring background command: ${error.message}`);
+        this.releasePane(execution, commandId);
+      }
+    };
+
+    this.activeCommands.get(commandId).monitor = monitor;
+    this.scheduleMonitor(commandId, monitor, 10000); // Start monitoring in 10 seconds
+  }
+
+  /**
+   * Keep a command that finished while its caller waited queryable by ID, like
+   * background ones, even with the history store off. Ephemeral results were
+   * already delivered once, so they aren't kept.
+   */
+  rememberResult(commandId, command, { status, output = null, exitCode = null, startTime, persistence = 'full', execution = {}, ...details }) {
+    if (persistence === 'ephemeral') {
+      return;
+    }
+
+    const commandInfo = {
+      command,
+      startTime,
+      persistence,
+      paneIndex: execution.paneIndex || this.tmux.ctPane,
+      branch: execution.branch || null,
+      execution,
+      status,
+      exitCode,
+      duration: ((Date.now() - startTime) / 1000).toFixed(1),
+      monitorTimer: null,
+      ...details
+    };
+    this.retainOutput(commandInfo, output);
+    this.activeCommands.set(commandId, commandInfo);
+  }
+
+  /**
+   * Persist a finished command to the history store; ephemeral commands never
+   * are, and metadata_only ones are recorded without output
+   */
+  recordHistory(commandId, command, { status, output = null, exitCode = null, startedAt, persistence = 'full', execution = {} }) {
+    if (persistence === 'ephemeral') {
+      return;
+    }
+
+    const completedAt = Date.now();
+    try {
+      this.history.record({
+        id: commandId,
+        command: this.redactor.redact(command),
+        status,
+        exit_code: exitCode,
+        output: persistence === 'metadata_only' ? null : this.redactor.redact(output),
+        pane: execution.paneIndex ?? null,
+        branch: execution.branch ?? null,
+        client: this.server.getClientVersion?.()?.name || null,
+        started_at: new Date(startedAt).toISOString(),
+        completed_at: new Date(completedAt).toISOString(),
+        duration_ms: completedAt - startedAt,
+        ...(execution.timedOut ? { timed_out: true } : {}),
+        ...(execution.repeatOf ? { repeat_of: execution.repeatOf } : {})
+      });
+    } catch (error) {
+      console.error(`⚠️ Failed to write command history: ${error.message}`);
+    }
+  }
+
+  /**
+   * Hand the pane to the next queued command, or close it if it was a fresh pane
+   */
+  releasePane(execution, commandId) {
+    // Put the user's layout back before the next queued command gets the pane
+    if (execution.originalWidth && !execution.freshPane) {
+      const width = execution.originalWidth;
+      execution.originalWidth = null;
+      this.tmux.resizePane(execution.paneId || execution.paneIndex, { width }).catch(error =>
+        console.error(`⚠️ Failed to restore width of pane ${execution.paneIndex}: ${error.message}`)
+      );
+    }
+
+    this.paneQueue.release(execution.paneIndex, commandId);
+
+    if (execution.freshPane && !execution.paneClosed) {
+      execution.paneClosed = true;
+      this.tmux.killPane(execution.paneIndex).catch(error =>
+        console.error(`⚠️ Failed to close fresh pane ${execution.paneIndex}: ${error.message}`)
+      );
+    }
+  }
+
+  /**
+   * Whether a tracked command is still running in its pane
+   */
+  isCommandActive(commandInfo) {
+    return ['running', 'ready', 'needs_interaction'].includes(commandInfo.status);
+  }
+
+  /**
+   * Schedule the next monitor poll, keeping the timer so cancellation can clear it
+   */
+  scheduleMonitor(commandId, monitor, delay) {
+    const commandInfo = this.activeCommands.get(commandId);
+    if (commandInfo) {
+      // While draining, poll every second so finishing commands are noticed quickly
+      commandInfo.monitorTimer = setTimeout(monitor, this.shuttingDown ? Math.min(delay, 1000) : delay);
+    }
+  }
+
+  /**
+   * Capture pane output and decide whether the command has finished
+   */
+  async captureCommandState({ paneIndex = null, paneId = null, command = null, typedCommand = null, sentinelId = null, snapshotBase = null, historyStart = null, preserveAnsi = false } = {}) {
+    // Never attribute another pane's output to this command
+    if (paneId) {
+      const currentId = await this.tmux.getPaneId(paneIndex);
+      if (currentId !== paneId) {
+        throw new Error(`PANE_CHANGED: pane ${paneIndex} is now ${currentId}, but the command started in ${paneId} (panes were rearranged or closed)`);
+      }
+    }
+
+    let scrollbackLines = 0;
+    if (historyStart !== null) {
+      const { historySize, historyLimit } = await this.tmux.getHistoryInfo(paneIndex);
+      scrollbackLines = Math.min(Math.max(historySize - historyStart, 0), historyLimit);
+    }
+
+    // Completion, prompts and assertions always work on plain text; with
+    // preserveAnsi the colored capture is kept alongside for delivery
+    const raw = await this.tmux.capturePane(paneIndex, scrollbackLines, { ansi: preserveAnsi });
+    // Show the command as the agent sent it, without the wrappers that were typed.
+    // commandOutput leaves out the prompt and echo, so assertions and readiness
+    // patterns can't match the command's own text.
+    const replaceEcho = text => typedCommand ? this.tmux.replaceCommandEcho(text, typedCommand, command) : { output: text, commandOutput: text };
+    const strip = text => snapshotBase ? this.tmux.stripEnvSnapshot(text, snapshotBase) : text;
+    const plain = replaceEcho(preserveAnsi ? this.tmux.cleanOutput(raw) : raw);
+    const output = strip(plain.output);
+    let commandOutput = strip(plain.commandOutput);
+    let ansiOutput = preserveAnsi ? strip(replaceEcho(raw).output) : null;
+
+    if (sentinelId) {
+      // Marker present means done; no need for process heuristics
+      const sentinel = this.tmux.parseSentinel(output, sentinelId);
+      commandOutput = this.tmux.parseSentinel(commandOutput, sentinelId).output;
+      if (ansiOutput !== null) {
+        ansiOutput = this.tmux.parseSentinel(ansiOutput, sentinelId).output;
+      }
+      return { output: sentinel.output, commandOutput, ansiOutput, exitCode: sentinel.exitCode, complete: sentinel.found };
+    }
+
+    return { output, commandOutput, ansiOutput, exitCode: null, complete: await this.tmux.isCommandComplete(paneIndex) };
+  }
+
+  /**
+   * Status view of a command recovered from the history store
+   */
+  formatHistoryEntry(entry) {
+    let text = `📊 Command Status: ${entry.command} (from history)\n` +
+               `🕒 Started: ${entry.started_at}\n` +
+               `🏁 Finished: ${entry.completed_at}\n` +
+               `⏱️ Duration: ${(entry.duration_ms / 1000).toFixed(1)}s\n` +
+               `📈 Status: ${entry.status}\n`;
+
+    if (entry.branch) {
+      text += `🌿 Branch: ${entry.branch}\n`;
+    }
+    if (entry.exit_code !== null && entry.exit_code !== undefined) {
+      text += `🔢 Exit code: ${entry.exit_code}\n`;
+    }
+    if (entry.output) {
+      text += `\n📋 Output:\n${entry.output}`;
+    }
+
+    return text;
+  }
+
+  /**
+   * Output lines that appeared since the previous delta check. Running commands
+   * are captured live; finished ones deliver whatever is left of the stored output.
+   */
+  async formatOutputDelta(commandInfo) {
+    let output = commandInfo.output || '';
+    if (this.isCommandActive(commandInfo) && commandInfo.execution) {
+      try {
+        const state = await this.captureCommandState(commandInfo.execution);
+        output = state.ansiOutput ?? state.output;
+      } catch (error) {
+        // Pane unavailable; fall back to the last stored output
+      }
+    }
+
+    const lines = output ? output.split('\n') : [];
+    const delivered = Math.min(commandInfo.deliveredLines || 0, lines.length);
+    const newLines = lines.slice(delivered);
+    commandInfo.deliveredLines = lines.length;
+    commandInfo.deltaSeq = (commandInfo.deltaSeq || 0) + 1;
+
+    let text = `\n🔢 Seq: ${commandInfo.deltaSeq} (lines ${delivered + 1}-${lines.length})\n`;
+    text += newLines.length > 0
+      ? `📋 New output:\n${newLines.join('\n')}`
+      : '📋 No new output';
+    return text;
+  }
+
+  /**
+   * Sample the pane for runaway output; interrupts the pane when policy says so
+   */
+  async checkRunaway(execution, output) {
+    if (!this.runawayDetector.isEnabled() || !execution.runaway) {
+      return null;
+    }
+
+    const { historySize, historyLimit } = await this.tmux.getHistoryInfo(execution.paneIndex);
+    let anomaly = this.runawayDetector.sample(execution.runaway, { historySize, historyLimit, output });
+    if (!anomaly) {
+      return null;
+    }
+
+    console.error(`⚠️ Runaway output detected in pane ${execution.paneIndex}: ${anomaly.reason}`);
+    // A pane the human has taken over is theirs to interrupt; only warn there
+    if (anomaly.policy === 'interrupt' && await this.tmux.getPaneControl(execution.paneIndex) === 'human') {
+      anomaly = { ...anomaly, policy: 'warn' };
+    }
+    if (anomaly.policy === 'interrupt') {
+      await this.tmux.sendInterrupt(execution.paneIndex);
+    } else {
+      execution.warnings.push(`Runaway output detected (${anomaly.reason})`);
+    }
+
+    return anomaly;
+  }
+
+  /**
+   * Trim output to the command's token budget, if it has one
+   */
+  applyBudget(output, execution) {
+    // Redact before budgeting and signing, so receipts cover what the client actually sees
+    output = this.redactor.redact(output);
+    if (!execution.maxTokens) {
+      return { text: output, note: '' };
+    }
+
+    const result = this.outputBudget.truncate(output, execution.maxTokens);
+    const truncatedText = result.truncated ? ` (truncated from ${result.originalTokens})` : '';
+    return {
+      text: result.text,
+      note: `\n\n🔢 Tokens delivered: ${result.tokens}${truncatedText} [${this.outputBudget.tokenizer.name}]`
+    };
+  }
+
+  /**
+   * Budget, redact and sign pane output for a result message. Every path that
+   * returns command output goes through here so none of them skip a step.
+   */
+  formatDelivery(commandId, command, { output, exitCode = null, startTime, execution, envText = '' }) {
+    const delivered = this.applyBudget(output, execution);
+    const receipt = this.signer.sign({
+      commandId,
+      command: this.redactor.redact(command),
+      output: delivered.text,
+      exitCode,
+      startedAt: startTime,
+      completedAt: Date.now()
+    });
+    const receiptText = receipt ? `\n\n🧾 Receipt: ${JSON.stringify(receipt)}` : '';
+    return {
+      delivered,
+      receipt,
+      details: `${delivered.text}${delivered.note}${envText}${this.formatWarnings(execution)}${receiptText}`
+    };
+  }
+
+  /**
+   * Format accumulated execution warnings for a result message
+   */
+  formatWarnings(execution) {
+    if (!execution.warnings || execution.warnings.length === 0) {
+      return '';
+    }
+    return '\n\n' + execution.warnings.map(warning => `⚠️ Warning: ${warning}`).join('\n');
+  }
+
+  /**
+   * Keep command output according to the command's persistence level
+   */
+  retainOutput(commandInfo, output) {
+    if (commandInfo.persistence === 'metadata_only') {
+      return;
+    }
+    commandInfo.output = output;
+  }
+
+  /**
+   * Get terminal status
+   */
+  async getTerminalStatus() {
+    await this.ensureInitialized();
+
+    const status = await this.tmux.getStatus();
+    
+    if (!status.connected) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❌ ${status.error}\n\n${status.suggestion || 'Please ensure you are running this MCP server from within a tmux session.'}`
+          }
+        ]
+      };
+    }
+
+    const paneInfo = status.ctPaneInfo ? 
+      `\nCT Pane size: ${status.ctPaneInfo.width}x${status.ctPaneInfo.height} at ${status.ctPaneInfo.path}` : 
+      '\nNo Claude Terminal pane configured';
+
+    // Get recent command history
+    let commandHistory = '';
+    if (status.ctPane) {
+      try {
+        const recentCommands = await this.tmux.getRecentCommands(3);
+        const formattedHistory = this.tmux.formatCommandHistory(recentCommands);
+        commandHistory = `\n\nRecent Commands:\n${formattedHistory}`;
+      } catch (error) {
+        commandHistory = `\n\nRecent Commands: Error retrieving history`;
+      }
+    }
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: `Connected to tmux session: ${status.session}\n` +
+                `Window: ${status.window} (${status.totalPanes} panes)\n` +
+                `Current pane: ${status.currentPane}\n` +
+                `Claude Terminal pane: ${status.ctPane || 'none'}` +
+                paneInfo +
+                commandHistory
+        }
+      ]
+    };
+  }
+
+  /**
+   * Create Claude Terminal pane
+   */
+  async createClaudeTerminal() {
+    await this.ensureInitialized();
+
+    try {
+      const result = await this.tmux.createClaudeTerminal();
+      
+      return {
+        content: [
+          {
+            type: 'text',
+            text: result.message + '\n\n🎯 Ready to execute commands in the Claude Terminal!'
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❌ Failed to create Claude Terminal: ${error.message}`
+          }
+        ]
+      };
+    }
+  }
+
+  /**
+   * Switch focus to Claude Terminal
+   */
+  async switchTerminalFocus() {
+    await this.ensureInitialized();
+
+    if (!this.tmux.ctPane) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No Claude Terminal pane available. Use create_claude_terminal to create one first.'
+          }
+        ]
+      };
+    }
+
+    try {
+      const result = await this.tmux.focusClaudeTerminal();
+      
+      return {
+        content: [
+          {
+            type: 'text',
+            text: result.message
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❌ Failed to switch focus: ${error.message}`
+          }
+        ]
+      };
+    }
+  }
+
+  /**
+   * Get command status
+   */
+  async getCommandStatus({ command_id, branch = null, delta = false } = {}) {
+    if (command_id) {
+      const commandInfo = this.activeCommands.get(command_id);
+      
+      if (!commandInfo) {
+        // Finished synchronously or before a restart: recover it from history
+        const entry = this.history.find(command_id);
+        if (entry) {
+          return {
+            content: [
+              {
+                type: 'text',
+                text: this.formatHistoryEntry(entry)
+              }
+            ]
+          };
+        }
+
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `❓ Command ID ${command_id} not found in active commands.`
+            }
+          ]
+        };
+      }
+
+      const duration = ((Date.now() - commandInfo.startTime) / 1000).toFixed(1);
+      let statusText = `📊 Command Status: ${commandInfo.command}\n` +
+                      `🕒 Started: ${new Date(commandInfo.startTime).toISOString()}\n` +
+                      `⏱️ Duration: ${commandInfo.duration ? commandInfo.duration + 's' : duration + 's (ongoing)'}\n` +
+                      `📈 Status: ${commandInfo.status}\n`;
+
+      if (commandInfo.branch) {
+        statusText += `🌿 Branch: ${commandInfo.branch}\n`;
+      }
+
+      if (commandInfo.status === 'queued') {
+        statusText += `⏳ Queue position: ${this.paneQueue.position(commandInfo.paneIndex, command_id)} (pane ${commandInfo.paneIndex})\n`;
+      }
+
+      if (commandInfo.exitCode !== undefined && commandInfo.exitCode !== null) {
+        statusText += `🔢 Exit code: ${commandInfo.exitCode}\n`;
+      }
+
+      if (commandInfo.assertionFailures?.length > 0) {
+        statusText += `❌ Assertions failed:\n${commandInfo.assertionFailures.map(failure => `- ${failure}`).join('\n')}\n`;
+      }
+
+      if (delta) {
+        statusText += await this.formatOutputDelta(commandInfo);
+      } else if (commandInfo.output) {
+        statusText += `\n📋 Output:\n${commandInfo.output}${commandInfo.budgetNote || ''}`;
+      }
+
+      if (commandInfo.error) {
+        statusText += `\n❌ Error: ${commandInfo.error}`;
+      }
+
+      if (commandInfo.execution?.warnings?.length > 0) {
+        statusText += this.formatWarnings(commandInfo.execution);
+      }
+
+      if (commandInfo.envDiff !== undefined) {
+        statusText += `\n\n🌱 Environment changes:\n${this.tmux.formatEnvironmentDiff(commandInfo.envDiff)}`;
+      }
+
+      if (commandInfo.receipt) {
+        statusText += `\n\n🧾 Receipt: ${JSON.stringify(commandInfo.receipt)}`;
+      }
+
+      // Ephemeral results are delivered once and then forgotten
+      if (commandInfo.persistence === 'ephemeral' && !this.isCommandActive(commandInfo) && commandInfo.status !== 'queued') {
+        this.activeCommands.delete(command_id);
+      }
+
+      return {
+        content: [
+          {
+            type: 'text',
+            text: statusText
+          }
+        ]
+      };
+    }
+
+    // List all active commands
+    if (this.activeCommands.size === 0) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📝 No active background commands.'
+          }
+        ]
+      };
+    }
+
+    const commands = [...this.activeCommands.entries()].filter(([, info]) => !branch || info.branch === branch);
+    let statusText = `📝 Active Commands (${commands.length}${branch ? ` on ${branch}` : ''}):\n\n`;
+    
+    for (const [id, info] of commands) {
+      const duration = (info.duration || ((Date.now() - info.startTime) / 1000).toFixed(1)) + 's';
+      statusText += `🔹 ${info.command}\n`;
+      statusText += `   ID: ${id}\n`;
+      statusText += `   Status: ${info.status} (${duration})\n`;
+      statusText += info.branch ? `   Branch: ${info.branch}\n\n` : '\n';
+    }
+
+    // Per-branch totals across everything tracked, so filtering is easy to pick
+    const branchCounts = new Map();
+    for (const info of this.activeCommands.values()) {
+      const name = info.branch || '(no repo)';
+      branchCounts.set(name, (branchCounts.get(name) || 0) + 1);
+    }
+    statusText += `🌿 By branch: ${[...branchCounts].map(([name, count]) => `${name} (${count})`).join(', ')}`;
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: statusText
+        }
+      ]
+    };
+  }
+
+  /**
+   * Cancel a running background command
+   */
+  async cancelCommand({ command_id }) {
+    const commandInfo = this.activeCommands.get(command_id);
+
+    if (!commandInfo) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❓ Command ID ${command_id} not found in active commands.`
+          }
+        ]
+      };
+    }
+
+    // Not started yet: just take it out of the pane queue
+    if (commandInfo.status === 'queued') {
+      this.paneQueue.remove(commandInfo.paneIndex, command_id);
+      commandInfo.status = 'cancelled';
+
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `🛑 ${commandInfo.command} removed from the pane ${commandInfo.paneIndex} queue before it started.`
+          }
+        ]
+      };
+    }
+
+    if (!this.isCommandActive(commandInfo)) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `ℹ️ ${commandInfo.command} is already ${commandInfo.status}; nothing to cancel.`
+          }
+        ]
+      };
+    }
+
+    const refusal = await this.checkAgentControl(commandInfo.paneIndex);
+    if (refusal) {
+      return refusal;
+    }
+
+    clearTimeout(commandInfo.monitorTimer);
+    await this.tmux.sendInterrupt(commandInfo.paneIndex);
+
+    commandInfo.status = 'cancelled';
+    commandInfo.duration = ((Date.now() - commandInfo.startTime) / 1000).toFixed(1);
+    this.releasePane(commandInfo.execution, command_id);
+    this.recordHistory(command_id, commandInfo.command, {
+      status: 'cancelled',
+      startedAt: commandInfo.startTime,
+      persistence: commandInfo.persistence,
+      execution: commandInfo.execution
+    });
+    console.error(`🛑 Cancelled background command: ${commandInfo.command}`);
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: `🛑 ${commandInfo.command} cancelled after ${commandInfo.duration}s (Ctrl+C sent to pane ${commandInfo.paneIndex}).`
+        }
+      ]
+    };
+  }
+
+  /**
+   * Send input to a running command and keep monitoring it
+   */
+  async sendCommandInput({ command_id, input, press_enter = true }) {
+    const commandInfo = this.activeCommands.get(command_id);
+
+    if (!commandInfo) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❓ Command ID ${command_id} not found in active commands.`
+          }
+        ]
+      };
+    }
+
+    if (!this.isCommandActive(commandInfo)) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `ℹ️ ${commandInfo.command} is already ${commandInfo.status}; it can't receive input.`
+          }
+        ]
+      };
+    }
+
+    const refusal = await this.checkAgentControl(commandInfo.paneIndex);
+    if (refusal) {
+      return refusal;
+    }
+
+    const denial = await this.checkApproval(`input "${input}" for ${commandInfo.command}`, commandInfo.paneIndex, uuidv4());
+    if (denial) {
+      return denial;
+    }
+
+    await this.tmux.sendRawKeys(input, true, commandInfo.paneIndex);
+    if (press_enter) {
+      await this.tmux.sendRawKeys('Enter', false, commandInfo.paneIndex);
+    }
+
+    // Check back soon rather than waiting for the regular poll
+    if (commandInfo.status === 'needs_interaction') {
+      commandInfo.status = 'running';
+      clearTimeout(commandInfo.monitorTimer);
+      this.scheduleMonitor(command_id, commandInfo.monitor, 2000);
+    }
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: `⌨️ Input sent to ${commandInfo.command} (pane ${commandInfo.paneIndex}). Use get_command_status to follow its progress.`
+        }
+      ]
+    };
+  }
+
+  /**
+   * Get terminal history with recent commands and outputs
+   */
+  async getTerminalHistory({ lines = 50, target_pane = null } = {}) {
+    await this.ensureInitialized();
+
+    const paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+          }
+        ]
+      };
+    }
+
+    try {
+      const history = await this.tmux.getTerminalHistory(lines, paneIndex);
+      
+      if (!history || history.trim() === '') {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `📝 No terminal history found in pane ${paneIndex}.`
+            }
+          ]
+        };
+      }
+
+      const paneLabel = target_pane ? ` from pane ${paneIndex}` : '';
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `📚 Terminal History${paneLabel} (last ${lines} lines):\n\n${history}`
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❌ Failed to get terminal history: ${error.message}`
+          }
+        ]
+      };
+    }
+  }
+
+  /**
+   * Show help and initialization guide
+   */
+  async getTerminalHelp({ section } = {}) {
+    let helpText;
+
+    try {
+      if (section) {
+        // Load specific section
+        helpText = this.helpLoader.getContextualHelp(section);
+      } else {
+        // Load complete help guide
+        helpText = this.helpLoader.getCompleteHelp();
+      }
+    } catch (error) {
+      helpText = `# Help System Error\n\nFailed to load help content: ${error.message}\n\nPlease check that help files are available in the help-content directory.`;
+    }
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: helpText
+        }
+      ]
+    };
+  }
+
+  // ===== PAGER DETECTION MCP TOOLS =====
+
+  /**
+   * Detect if a pager is currently active in the target pane
+   */
+  async detectPager({ target_pane = null } = {}) {
+    // For pager detection with explicit target_pane, we can bypass CT pane requirement
+    if (target_pane) {
+      try {
+        // Just ensure tmux environment is detected
+        await this.tmux.detectTmuxEnvironment();
+        const state = await this.tmux.detectPagerState(target_pane);
+        
+        if (state.error) {
+          return {
+            content: [
+              {
+                type: 'text',
+                text: `Error detecting pager: ${state.error}`
+              }
+            ]
+          };
+        }
+
+        const statusText = state.isPager ? 
+          `Pager detected: ${state.command} (confidence: ${state.confidence})${state.alternateScreen ? ' [alternate screen]' : ''}` :
+          'No pager detected';
+
+        return {
+          content: [
+            {
+              type: 'text', 
+              text: statusText
+            }
+          ]
+        };
+      } catch (error) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `Failed to detect pager: ${error.message}`
+            }
+          ]
+        };
+      }
+    }
+
+    // Default behavior requires full initialization
+    await this.ensureInitialized();
+
+    try {
+      const state = await this.tmux.detectPagerState(target_pane);
+      
+      if (state.error) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `Error detecting pager: ${state.error}`
+            }
+          ]
+        };
+      }
+
+      const statusText = state.isPager ? 
+        `Pager detected: ${state.command} (confidence: ${state.confidence})${state.alternateScreen ? ' [alternate screen]' : ''}` :
+        'No pager detected';
+
+      return {
+        content: [
+          {
+            type: 'text', 
+            text: statusText
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `Failed to detect pager: ${error.message}`
+          }
+        ]
+      };
+    }
+  }
+
+  /**
+   * Get detailed pager information with suggested actions
+   */
+  async getPagerInfo({ target_pane = null } = {}) {
+    // For pager info with explicit target_pane, bypass CT pane requirement
+    if (target_pane) {
+      try {
+        await this.tmux.detectTmuxEnvironment();
+        const info = await this.tmux.getPagerInfo(target_pane);
+        
+        if (!info.active) {
+          return {
+            content: [
+              {
+                type: 'text',
+                text: 'No active pager detected'
+              }
+            ]
+          };
+        }
+
+        const actionsText = info.suggestedActions.map(action => `- ${action}`).join('\n');
+        const keysText = Object.entries(info.commonKeys)
+          .map(([key, desc]) => `  ${key}: ${desc}`)
+          .join('\n');
+
+        const infoText = `Active Pager: ${info.command}
+Alternate Screen: ${info.alternateScreen ? 'Yes' : 'No'}
+Confidence: ${info.confidence}
+
+Suggested Actions:
+${actionsText}
+
+Common Keys:
+${keysText}`;
+
+        return {
+          content: [
+            {
+              type: 'text',
+              text: infoText
+            }
+          ]
+        };
+      } catch (error) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `Failed to get pager info: ${error.message}`
+            }
+          ]
+        };
+      }
+    }
+
+    await this.ensureInitialized();
+
+    try {
+      const info = await this.tmux.getPagerInfo(target_pane);
       
+      if (!info.active) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: 'No active pager detected'
+            }
+          ]
+        };
+      }
+
+      const actionsText = info.suggestedActions.map(action => `- ${action}`).join('\n');
+      const keysText = Object.entries(info.commonKeys)
+        .map(([key, desc]) => `  ${key}: ${desc}`)
+        .join('\n');
+
+      const infoText = `Active Pager: ${info.command}
+Alternate Screen: ${info.alternateScreen ? 'Yes' : 'No'}
+Confidence: ${info.confidence}
+
+Suggested Actions:
+${actionsText}
+
+Common Keys:
+${keysText}`;
+
+      return {
+        content: [
+          {
+            type: 'text',
+            text: infoText
+          }
+        ]
+      };
+    } catch (error) {
       return {
         content: [
           {
             type: 'text',
-            text: `🔄 ${command} started in Claude Terminal (pane ${this.tmux.ctPane})\n\n${timeoutStrategy.reason}\n\nCommand ID: ${commandId}\nUse get_command_status to check progress.`
+            text: `Failed to get pager info: ${error.message}`
           }
         ]
       };
     }
+  }
 
-    // Wait briefly for completion
-    const result = await this.waitForCommandCompletion(commandId, command, timeoutStrategy.timeout);
-    
+  /**
+   * Send keys to active pager
+   */
+  async sendPagerKeys({ keys, target_pane = null }) {
+    await this.ensureInitialized();
+
+    const refusal = await this.checkAgentControl(target_pane || this.tmux.ctPane);
+    if (refusal) {
+      return refusal;
+    }
+
+    try {
+      // First check if pager is active
+      const isPagerActive = await this.tmux.isPagerActive(target_pane);
+      if (!isPagerActive) {
+        return {
+          content: [
+            {
+              type: 'text', 
+              text: 'No active pager detected. Cannot send keys.'
+            }
+          ]
+        };
+      }
+
+      // Pagers like less can run shell commands (!cmd), so keys need approval too
+      const denial = await this.checkApproval(`pager keys "${keys}"`, target_pane || this.tmux.ctPane, uuidv4());
+      if (denial) {
+        return denial;
+      }
+
+      // Send keys to pager
+      const result = await this.tmux.sendPagerKeys(keys, target_pane);
+      
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `Keys "${keys}" sent to pager in pane ${result.pane}`
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `Failed to send keys to pager: ${error.message}`
+          }
+        ]
+      };
+    }
+  }
+
+  /**
+   * Refuse agent input to a pane the human has taken over
+   */
+  async checkAgentControl(paneIndex) {
+    if (await this.tmux.getPaneControl(paneIndex) !== 'human') {
+      return null;
+    }
+
+    this.audit('policy_denial', { pane: paneIndex, policy: 'human_control' });
     return {
       content: [
         {
           type: 'text',
-          text: result
+          text: `✋ Pane ${paneIndex} is under human control. Wait for the user to hand it back (handoff_pane release asks them in tmux).`
         }
       ]
     };
   }
 
   /**
-   * Wait for command completion with timeout
+   * In approval mode, hold the command until the human picks Run in the tmux
+   * menu. Returns a refusal result if they deny it or don't answer in time.
    */
-  async waitForCommandCompletion(commandId, command, timeoutMs) {
-    const startTime = Date.now();
-    const maxWaitTime = timeoutMs || 5000;
-    let lastOutput = '';
+  async checkApproval(command, paneIndex, approvalId, { ephemeral = false } = {}) {
+    if (!this.requireApproval) {
+      return null;
+    }
 
-    while (Date.now() - startTime < maxWaitTime) {
-      await new Promise(resolve => setTimeout(resolve, 500));
-      
-      try {
-        const output = await this.tmux.capturePane();
-        
-        if (await this.tmux.isCommandComplete()) {
-          const duration = ((Date.now() - startTime) / 1000).toFixed(1);
-          return `✅ ${command} completed in ${duration}s:\n\n${output}`;
-        }
+    let answer;
+    try {
+      answer = await this.tmux.requestApproval(command, approvalId.slice(0, 8), this.approvalTimeout * 1000, paneIndex);
+    } catch (error) {
+      answer = `unavailable (${error.message})`;
+    }
+    this.audit('approval', { command: ephemeral ? '[ephemeral command]' : command, pane: paneIndex, decision: answer });
+    if (answer === 'approved') {
+      return null;
+    }
 
-        // Check for interactive prompts
-        if (this.tmux.detectInteractivePrompts(output) && output !== lastOutput) {
-          await this.tmux.focusClaudeTerminal();
-          return `🔐 Interactive prompt detected in Claude Terminal (pane ${this.tmux.ctPane}). Focus switched for user input.\n\nCurrent output:\n${output}`;
+    console.error(`🙅 Command not approved (${answer}): ${ephemeral ? '[ephemeral command]' : command}`);
+    return {
+      content: [
+        {
+          type: 'text',
+          text: answer === 'denied' ? `🙅 The user denied running ${command} in pane ${paneIndex}.` :
+            answer === 'timeout' ? `⌛ No approval for ${command} within ${this.approvalTimeout}s; it was not run.` :
+            `🙅 ${command} was not run: approval is required but the prompt is ${answer}.`
         }
+      ],
+      isError: true
+    };
+  }
 
-        lastOutput = output;
-      } catch (error) {
-        return `❌ Error monitoring command: ${error.message}`;
+  /**
+   * Hand a pane to the human, take it back, or report who has it
+   */
+  async handoffPane({ action, target_pane = null }) {
+    await this.ensureInitialized();
+
+    const paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+          }
+        ]
+      };
+    }
+
+    try {
+      let text;
+
+      if (action === 'request') {
+        await this.tmux.setPaneControl('requested', paneIndex);
+        await this.tmux.promptHandoff(paneIndex);
+        text = `🤝 Handoff of pane ${paneIndex} requested. The user has been asked in tmux to take control; check with handoff_pane status.`;
+      } else if (action === 'release') {
+        // Only the human can hand a pane back; a request they never accepted can just be withdrawn
+        const control = await this.tmux.getPaneControl(paneIndex);
+        if (control === 'human') {
+          await this.tmux.promptRelease(paneIndex);
+          text = `🤝 The user has been asked in tmux to hand pane ${paneIndex} back; check with handoff_pane status.`;
+        } else if (control === 'requested') {
+          await this.tmux.setPaneControl('agent', paneIndex);
+          text = `🤖 Handoff request for pane ${paneIndex} withdrawn.`;
+        } else {
+          text = `🤖 Pane ${paneIndex} is already controlled by Claude.`;
+        }
+      } else {
+        const control = await this.tmux.getPaneControl(paneIndex);
+        text = `🎛️ Pane ${paneIndex} is controlled by: ${control}`;
       }
+
+      console.error(`🤝 Handoff ${action} for pane ${paneIndex}`);
+      return {
+        content: [
+          {
+            type: 'text',
+            text
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❌ Handoff failed: ${error.message}`
+          }
+        ]
+      };
     }
+  }
 
-    // Timeout reached, switch to async monitoring
-    this.monitorAsyncCommand(commandId, command, this.detector.analyzeCommand(command));
-    const duration = ((Date.now() - startTime) / 1000).toFixed(1);
-    
-    return `🔄 ${command} running longer than expected (${duration}s+). Switched to background monitoring.\n\nCommand ID: ${commandId}\nUse get_command_status to check progress.`;
+  /**
+   * Indexes of panes after `paneIndex` that a tracked command currently owns
+   */
+  async busyPanesAfter(paneIndex) {
+    const index = await this.tmux.getPaneIndex(paneIndex);
+    return (await this.tmux.listPanes())
+      .map(pane => pane.index)
+      .filter(later => later > index && (this.paneQueue.isBusy(later) || this.paneQueue.isBusy(String(later))));
   }
 
   /**
-   * Monitor long-running command asynchronously
+   * Split, kill, resize or respawn a pane
    */
-  async monitorAsyncCommand(commandId, command, analysis) {
-    this.activeCommands.set(commandId, {
-      command,
-      startTime: Date.now(),
-      analysis,
-      status: 'running'
-    });
+  async managePane({ action, target_pane = null, direction = 'horizontal', size = null, width = null, height = null }) {
+    await this.ensureInitialized();
 
-    // Poll every 10 seconds for completion
-    const monitor = async () => {
-      try {
-        const output = await this.tmux.capturePane();
-        
-        if (await this.tmux.isCommandComplete()) {
-          const commandInfo = this.activeCommands.get(commandId);
-          if (commandInfo) {
-            const duration = ((Date.now() - commandInfo.startTime) / 1000).toFixed(1);
-            commandInfo.status = 'completed';
-            commandInfo.output = output;
-            commandInfo.duration = duration;
-            
-            console.error(`✅ Background command completed: ${command} (${duration}s)`);
+    const paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
           }
-          return;
-        }
+        ]
+      };
+    }
 
-        // Check for interactive prompts
-        if (this.tmux.detectInteractivePrompts(output)) {
-          const commandInfo = this.activeCommands.get(commandId);
-          if (commandInfo) {
-            commandInfo.status = 'needs_interaction';
-            commandInfo.output = output;
-            
-            console.error(`🔐 Background command needs interaction: ${command}`);
-            await this.tmux.focusClaudeTerminal();
-          }
-          return;
-        }
+    if (action === 'kill' || action === 'respawn') {
+      // Never take down the pane Claude itself is running in
+      if (String(paneIndex) === String(this.tmux.currentPane)) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `🚫 Pane ${paneIndex} is where Claude is running; refusing to ${action} it.`
+            }
+          ]
+        };
+      }
 
-        // Continue monitoring
-        setTimeout(monitor, 10000);
-        
-      } catch (error) {
-        const commandInfo = this.activeCommands.get(commandId);
-        if (commandInfo) {
-          commandInfo.status = 'error';
-          commandInfo.error = error.message;
+      const refusal = await this.checkAgentControl(paneIndex);
+      if (refusal) {
+        return refusal;
+      }
+
+      if (this.paneQueue.isBusy(paneIndex)) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `⏳ Pane ${paneIndex} is running a tracked command. Cancel it with cancel_command before you ${action} the pane.`
+            }
+          ]
+        };
+      }
+    }
+
+    // Splitting or killing shifts the indexes of every later pane; commands
+    // tracked there would lose their pane and fail with PANE_CHANGED
+    if (action === 'split' || action === 'kill') {
+      const busy = await this.busyPanesAfter(paneIndex);
+      if (busy.length > 0) {
+        return {
+          content: [
+            {
+              type: 'text',
+              text: `⏳ Pane(s) ${busy.join(', ')} are running tracked commands and would be renumbered. Wait for them or cancel them before you ${action} pane ${paneIndex}.`
+            }
+          ]
+        };
+      }
+    }
+
+    try {
+      let text;
+
+      if (action === 'split') {
+        const newPane = await this.tmux.splitPane(paneIndex, direction, { size });
+        text = `✅ Split pane ${paneIndex} ${direction}ly; new pane is ${newPane}`;
+      } else if (action === 'kill') {
+        await this.tmux.killPane(paneIndex);
+        text = `🗑️ Killed pane ${paneIndex}`;
+      } else if (action === 'resize') {
+        if (!width && !height) {
+          throw new Error('resize needs width and/or height');
         }
-        console.error(`❌ Error monitoring background command: ${error.message}`);
+        await this.tmux.resizePane(paneIndex, { width, height });
+        text = `📐 Resized pane ${paneIndex}${width ? ` to ${width} columns` : ''}${height ? `${width ? ' and' : ' to'} ${height} rows` : ''}`;
+      } else {
+        await this.tmux.respawnPane(paneIndex);
+        text = `♻️ Respawned the shell in pane ${paneIndex}`;
       }
-    };
 
-    setTimeout(monitor, 10000); // Start monitoring in 10 seconds
+      console.error(`🧱 manage_pane ${action} on pane ${paneIndex}`);
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `${text}\nClaude Terminal pane: ${this.tmux.ctPane || 'none'}`
+          }
+        ]
+      };
+    } catch (error) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❌ manage_pane ${action} failed: ${error.message}`
+          }
+        ]
+      };
+    }
   }
 
   /**
-   * Get terminal status
+   * Run one command in several panes simultaneously and aggregate the results
    */
-  async getTerminalStatus() {
+  async broadcastInput({ command, panes = null, group = null, timeout = 30 }) {
     await this.ensureInitialized();
 
-    const status = await this.tmux.getStatus();
-    
-    if (!status.connected) {
+    const targets = [...new Set([...(panes || []), ...(group ? await this.tmux.getGroupPanes(group) : [])])];
+    if (targets.length === 0) {
       return {
         content: [
           {
             type: 'text',
-            text: `❌ ${status.error}\n\n${status.suggestion || 'Please ensure you are running this MCP server from within a tmux session.'}`
+            text: group ? `📋 No panes are tagged with group ${group}.` : '📋 Specify panes or a group to broadcast to.'
           }
         ]
       };
     }
 
-    const paneInfo = status.ctPaneInfo ? 
-      `\nCT Pane size: ${status.ctPaneInfo.width}x${status.ctPaneInfo.height} at ${status.ctPaneInfo.path}` : 
-      '\nNo Claude Terminal pane configured';
+    const broadcastId = uuidv4();
+    const denial = await this.checkApproval(`${command} (broadcast to panes ${targets.join(', ')})`, targets[0], broadcastId);
+    if (denial) {
+      return denial;
+    }
 
-    // Get recent command history
-    let commandHistory = '';
-    if (status.ctPane) {
-      try {
-        const recentCommands = await this.tmux.getRecentCommands(3);
-        const formattedHistory = this.tmux.formatCommandHistory(recentCommands);
-        commandHistory = `\n\nRecent Commands:\n${formattedHistory}`;
-      } catch (error) {
-        commandHistory = `\n\nRecent Commands: Error retrieving history`;
+    const results = new Map();
+    const runs = [];
+
+    for (const paneIndex of targets) {
+      if (this.paneQueue.isBusy(paneIndex)) {
+        results.set(paneIndex, '⏳ skipped: another command is running there');
+        continue;
+      }
+      if (await this.tmux.getPaneControl(paneIndex) === 'human') {
+        results.set(paneIndex, '✋ skipped: under human control');
+        continue;
+      }
+
+      // Each pane gets its own command ID, history entry and completion marker.
+      // Shells that can't run the marker fall back to prompt detection.
+      const commandId = `${broadcastId}-${paneIndex}`;
+      const sentinelId = this.tmux.supportsSentinel(await this.tmux.getPaneShell(paneIndex)) ? `${broadcastId.slice(0, 8)}${paneIndex}` : null;
+      const execution = {
+        commandId,
+        paneIndex,
+        sentinelId,
+        command,
+        typedCommand: this.scratch.wrap(sentinelId ? this.tmux.wrapWithSentinel(command, sentinelId) : command),
+        runaway: {},
+        warnings: []
+      };
+      this.paneQueue.acquire(paneIndex, commandId);
+      runs.push(execution);
+    }
+
+    console.error(`📡 Broadcasting to panes ${runs.map(run => run.paneIndex).join(', ')}: ${command}`);
+
+    let pending = [...runs];
+    try {
+      await Promise.all(runs.map(execution =>
+        this.startCommand(execution.paneIndex, execution.typedCommand, execution)
+      ));
+
+      const startTime = Date.now();
+      const deadline = startTime + timeout * 1000;
+      for (const execution of runs) {
+        this.pendingWaits.set(execution.commandId, { command, paneIndex: execution.paneIndex, startTime, persistence: 'full', execution });
+      }
+      while (pending.length > 0) {
+        await new Promise(resolve => setTimeout(resolve, 500));
+        const states = await Promise.all(pending.map(execution => this.captureCommandState(execution)));
+
+        pending = pending.filter((execution, i) => {
+          const { output, exitCode, complete } = states[i];
+          if (complete) {
+            const failed = exitCode !== null && exitCode !== 0;
+            const exitText = exitCode !== null ? `exit code ${exitCode}` : 'finished (exit code unknown)';
+            results.set(execution.paneIndex, `${failed ? '❌' : '✅'} ${exitText}\n${output}`);
+            this.pendingWaits.delete(execution.commandId);
+            this.recordHistory(execution.commandId, command, {
+              status: failed ? 'failed' : 'completed',
+              output,
+              exitCode,
+              startedAt: startTime,
+              execution
+            });
+            this.releasePane(execution, execution.commandId);
+            return false;
+          }
+          if (Date.now() >= deadline) {
+            // Keep the pane reserved; background monitoring writes history once it finishes
+            this.pendingWaits.delete(execution.commandId);
+            execution.timedOut = true;
+            this.monitorAsyncCommand(execution.commandId, command, this.detector.analyzeCommand(command), { execution, startTime });
+            results.set(execution.paneIndex, `🔄 still running after ${timeout}s, monitoring in background (Command ID: ${execution.commandId})\n${output}`);
+            return false;
+          }
+          return true;
+        });
+      }
+    } finally {
+      // Panes that never finished or reached background monitoring (e.g. startCommand failed)
+      for (const execution of pending) {
+        this.pendingWaits.delete(execution.commandId);
+        this.releasePane(execution, execution.commandId);
       }
     }
 
+    const sections = targets.map(paneIndex => `── Pane ${paneIndex} ──\n${results.get(paneIndex)}`);
     return {
       content: [
         {
           type: 'text',
-          text: `Connected to tmux session: ${status.session}\n` +
-                `Window: ${status.window} (${status.totalPanes} panes)\n` +
-                `Current pane: ${status.currentPane}\n` +
-                `Claude Terminal pane: ${status.ctPane || 'none'}` +
-                paneInfo +
-                commandHistory
+          text: `📡 ${command} broadcast to ${targets.length} pane(s):\n\n${sections.join('\n\n')}`
         }
       ]
     };
   }
 
   /**
-   * Create Claude Terminal pane
+   * Send raw keys to a pane
    */
-  async createClaudeTerminal() {
+  async sendKeys({ keys, literal = false, target_pane = null }) {
     await this.ensureInitialized();
 
+    const paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+          }
+        ]
+      };
+    }
+
+    const refusal = await this.checkAgentControl(paneIndex);
+    if (refusal) {
+      return refusal;
+    }
+
+    // Keys can type and run a command just like execute_terminal_command
+    const keyText = Array.isArray(keys) ? keys.join(' ') : keys;
+    const denial = await this.checkApproval(`${literal ? 'text' : 'keys'} "${keyText}"`, paneIndex, uuidv4());
+    if (denial) {
+      return denial;
+    }
+
     try {
-      const result = await this.tmux.createClaudeTerminal();
-      
+      await this.tmux.sendRawKeys(keys, literal, paneIndex);
+
       return {
         content: [
           {
             type: 'text',
-            text: result.message + '\n\n🎯 Ready to execute commands in the Claude Terminal!'
+            text: `⌨️ Sent ${literal ? 'text' : 'keys'} "${keyText}" to pane ${paneIndex}`
           }
         ]
       };
@@ -556,7 +2888,7 @@ class TmuxTerminalMCP {
         content: [
           {
             type: 'text',
-            text: `❌ Failed to create Claude Terminal: ${error.message}`
+            text: `❌ Failed to send keys: ${error.message}`
           }
         ]
       };
@@ -564,30 +2896,50 @@ class TmuxTerminalMCP {
   }
 
   /**
-   * Switch focus to Claude Terminal
+   * Verify a signed command receipt
    */
-  async switchTerminalFocus() {
-    await this.ensureInitialized();
-
-    if (!this.tmux.ctPane) {
+  async verifyReceipt({ receipt, output = null }) {
+    let parsed;
+    try {
+      parsed = JSON.parse(receipt);
+    } catch (error) {
       return {
         content: [
           {
             type: 'text',
-            text: '📋 No Claude Terminal pane available. Use create_claude_terminal to create one first.'
+            text: `❌ Receipt is not valid JSON: ${error.message}`
           }
         ]
       };
     }
 
+    const result = this.signer.verify(parsed, output);
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: result.valid ?
+            `✅ Receipt for ${parsed.command_id} is valid` :
+            `❌ Receipt verification failed: ${result.reason}`
+        }
+      ]
+    };
+  }
+
+  /**
+   * Get the workspace file tree
+   */
+  async getWorkspaceTree({ path = null, depth = 2 } = {}) {
     try {
-      const result = await this.tmux.focusClaudeTerminal();
-      
+      const result = await this.workspaceTree.buildTree(path, depth);
+      const truncatedText = result.truncated ? ` (truncated at ${result.entries} entries)` : '';
+
       return {
         content: [
           {
             type: 'text',
-            text: result.message
+            text: `📁 Workspace tree${truncatedText}:\n\n${JSON.stringify(result.tree, null, 2)}`
           }
         ]
       };
@@ -596,7 +2948,7 @@ class TmuxTerminalMCP {
         content: [
           {
             type: 'text',
-            text: `❌ Failed to switch focus: ${error.message}`
+            text: `❌ Failed to get workspace tree: ${error.message}`
           }
         ]
       };
@@ -604,382 +2956,452 @@ class TmuxTerminalMCP {
   }
 
   /**
-   * Get command status
+   * Query the persisted command history
    */
-  async getCommandStatus({ command_id } = {}) {
-    if (command_id) {
-      const commandInfo = this.activeCommands.get(command_id);
-      
-      if (!commandInfo) {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: `❓ Command ID ${command_id} not found in active commands.`
-            }
-          ]
-        };
-      }
-
-      const duration = ((Date.now() - commandInfo.startTime) / 1000).toFixed(1);
-      let statusText = `📊 Command Status: ${commandInfo.command}\n` +
-                      `⏱️ Duration: ${commandInfo.duration || duration + 's (ongoing)'}\n` +
-                      `📈 Status: ${commandInfo.status}\n`;
-
-      if (commandInfo.output) {
-        statusText += `\n📋 Output:\n${commandInfo.output}`;
-      }
-
-      if (commandInfo.error) {
-        statusText += `\n❌ Error: ${commandInfo.error}`;
-      }
-
+  async getCommandHistory({ status = null, branch = null, pane = null, contains = null, since = null, limit = 20, include_output = false } = {}) {
+    if (!this.history.isEnabled()) {
       return {
         content: [
           {
             type: 'text',
-            text: statusText
+            text: '📚 Command history is not being recorded. Set TMUX_MCP_HISTORY_FILE to a file path to enable it.'
           }
         ]
       };
     }
 
-    // List all active commands
-    if (this.activeCommands.size === 0) {
+    const entries = this.history.query({ status, branch, pane, contains, since, limit });
+    if (entries.length === 0) {
       return {
         content: [
           {
             type: 'text',
-            text: '📝 No active background commands.'
+            text: '📚 No recorded commands match.'
           }
         ]
       };
     }
 
-    let statusText = `📝 Active Commands (${this.activeCommands.size}):\n\n`;
-    
-    for (const [id, info] of this.activeCommands.entries()) {
-      const duration = info.duration || ((Date.now() - info.startTime) / 1000).toFixed(1) + 's';
-      statusText += `🔹 ${info.command}\n`;
-      statusText += `   ID: ${id}\n`;
-      statusText += `   Status: ${info.status} (${duration})\n\n`;
+    let text = `📚 Command history (${entries.length}):\n\n`;
+    for (const entry of entries) {
+      const exitText = entry.exit_code !== null ? `, exit ${entry.exit_code}` : '';
+      text += `🔹 ${entry.command}\n`;
+      text += `   ${entry.started_at} · ${entry.status}${exitText} · ${(entry.duration_ms / 1000).toFixed(1)}s · pane ${entry.pane}${entry.branch ? ` · ${entry.branch}` : ''}\n`;
+      if (entry.tombstone) {
+        text += `   🧹 Redacted ${entry.tombstone.at}${entry.tombstone.by ? ` by ${entry.tombstone.by}` : ''}: ${entry.tombstone.reason}\n`;
+      }
+      if (include_output && entry.output) {
+        text += `   Output:\n${entry.output.split('\n').map(line => `     ${line}`).join('\n')}\n`;
+      }
+      text += '\n';
     }
 
     return {
       content: [
         {
           type: 'text',
-          text: statusText
+          text: text.trimEnd()
         }
       ]
     };
   }
 
   /**
-   * Get terminal history with recent commands and outputs
+   * Redact or delete a recorded command, leaving a tombstone, and scrub its
+   * command text from the audit log
    */
-  async getTerminalHistory({ lines = 50, target_pane = null } = {}) {
-    await this.ensureInitialized();
-
-    const paneIndex = target_pane || this.tmux.ctPane;
-    if (!paneIndex) {
+  async redactHistory({ command_id, action = 'redact', reason }) {
+    if (!this.history.isEnabled()) {
       return {
         content: [
           {
             type: 'text',
-            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+            text: '📚 Command history is not being recorded. Set TMUX_MCP_HISTORY_FILE to a file path to enable it.'
           }
         ]
       };
     }
 
-    try {
-      const history = await this.tmux.getTerminalHistory(lines, paneIndex);
-      
-      if (!history || history.trim() === '') {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: `📝 No terminal history found in pane ${paneIndex}.`
-            }
-          ]
-        };
-      }
-
-      const paneLabel = target_pane ? ` from pane ${paneIndex}` : '';
-      return {
-        content: [
-          {
-            type: 'text',
-            text: `📚 Terminal History${paneLabel} (last ${lines} lines):\n\n${history}`
-          }
-        ]
-      };
-    } catch (error) {
+    const tombstone = {
+      action,
+      by: this.server.getClientVersion?.()?.name || null,
+      at: new Date().toISOString(),
+      reason
+    };
+    const original = this.history.redact(command_id, tombstone);
+    if (!original) {
       return {
         content: [
           {
             type: 'text',
-            text: `❌ Failed to get terminal history: ${error.message}`
+            text: `❌ No recorded command with ID ${command_id}`
           }
-        ]
+        ],
+        isError: true
       };
     }
-  }
 
-  /**
-   * Show help and initialization guide
-   */
-  async getTerminalHelp({ section } = {}) {
-    let helpText;
+    // The in-memory result would still hand the output back
+    const commandInfo = this.activeCommands.get(command_id);
+    if (commandInfo && !this.isCommandActive(commandInfo)) {
+      this.activeCommands.delete(command_id);
+    }
 
+    let auditEntries = 0;
     try {
-      if (section) {
-        // Load specific section
-        helpText = this.helpLoader.getContextualHelp(section);
-      } else {
-        // Load complete help guide
-        helpText = this.helpLoader.getCompleteHelp();
-      }
+      auditEntries = this.auditLog.scrub(original.command, tombstone);
     } catch (error) {
-      helpText = `# Help System Error\n\nFailed to load help content: ${error.message}\n\nPlease check that help files are available in the help-content directory.`;
+      console.error(`⚠️ Failed to scrub the audit log: ${error.message}`);
     }
+    // Recorded after scrubbing, and without the command text, so the removal itself stays on record
+    this.audit('history_redacted', { command_id, action, reason, audit_entries: auditEntries });
 
     return {
       content: [
         {
           type: 'text',
-          text: helpText
+          text: `🧹 ${action === 'delete' ? 'Deleted' : 'Redacted'} command ${command_id} from history` +
+                (this.auditLog.isEnabled() ? ` and ${auditEntries} audit log entr${auditEntries === 1 ? 'y' : 'ies'}` : '') +
+                `. Tombstone: ${JSON.stringify(tombstone)}`
         }
       ]
     };
   }
 
-  // ===== PAGER DETECTION MCP TOOLS =====
-
-  /**
-   * Detect if a pager is currently active in the target pane
-   */
-  async detectPager({ target_pane = null } = {}) {
-    // For pager detection with explicit target_pane, we can bypass CT pane requirement
-    if (target_pane) {
-      try {
-        // Just ensure tmux environment is detected
-        await this.tmux.detectTmuxEnvironment();
-        const state = await this.tmux.detectPagerState(target_pane);
-        
-        if (state.error) {
-          return {
-            content: [
-              {
-                type: 'text',
-                text: `Error detecting pager: ${state.error}`
-              }
-            ]
-          };
-        }
-
-        const statusText = state.isPager ? 
-          `Pager detected: ${state.command} (confidence: ${state.confidence})${state.alternateScreen ? ' [alternate screen]' : ''}` :
-          'No pager detected';
-
-        return {
-          content: [
-            {
-              type: 'text', 
-              text: statusText
-            }
-          ]
-        };
-      } catch (error) {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: `Failed to detect pager: ${error.message}`
-            }
-          ]
-        };
-      }
-    }
-
-    // Default behavior requires full initialization
-    await this.ensureInitialized();
-
-    try {
-      const state = await this.tmux.detectPagerState(target_pane);
-      
-      if (state.error) {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: `Error detecting pager: ${state.error}`
-            }
-          ]
-        };
-      }
-
-      const statusText = state.isPager ? 
-        `Pager detected: ${state.command} (confidence: ${state.confidence})${state.alternateScreen ? ' [alternate screen]' : ''}` :
-        'No pager detected';
-
+  /**
+   * Summarize the persisted command history
+   */
+  async getUsageStats({ since = null, top = 5 } = {}) {
+    if (!this.history.isEnabled()) {
       return {
         content: [
           {
-            type: 'text', 
-            text: statusText
+            type: 'text',
+            text: '📚 Command history is not being recorded. Set TMUX_MCP_HISTORY_FILE to a file path to enable it.'
           }
         ]
       };
-    } catch (error) {
+    }
+
+    const stats = summarizeUsage(this.history.query({ since, limit: Infinity }), { top });
+    if (stats.total === 0) {
       return {
         content: [
           {
             type: 'text',
-            text: `Failed to detect pager: ${error.message}`
+            text: '📊 No recorded commands to summarize.'
           }
         ]
       };
     }
+
+    const percent = rate => rate === null ? 'n/a' : `${(rate * 100).toFixed(1)}%`;
+    let text = `📊 Usage over ${stats.total} command(s)${since ? ` since ${since}` : ''}:\n\n`;
+    text += `Success rate: ${percent(stats.successRate)} · Timeout rate: ${percent(stats.timeoutRate)} · ` +
+      `Average duration: ${stats.averageDurationMs === null ? 'n/a' : `${(stats.averageDurationMs / 1000).toFixed(1)}s`}\n\n`;
+    text += `Per day:\n${stats.days.map(day => `   ${day.date}: ${day.commands}`).join('\n')}\n\n`;
+    text += `Busiest panes:\n${stats.busiestPanes.map(pane => `   pane ${pane.pane}: ${pane.commands}`).join('\n')}\n\n`;
+    text += `Top commands:\n${stats.topCommands.map(command => `   ${command.runs}× ${command.command}`).join('\n')}`;
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text
+        }
+      ]
+    };
   }
 
   /**
-   * Get detailed pager information with suggested actions
+   * Capture all panes in the current window concurrently
    */
-  async getPagerInfo({ target_pane = null } = {}) {
-    // For pager info with explicit target_pane, bypass CT pane requirement
-    if (target_pane) {
-      try {
-        await this.tmux.detectTmuxEnvironment();
-        const info = await this.tmux.getPagerInfo(target_pane);
-        
-        if (!info.active) {
-          return {
-            content: [
-              {
-                type: 'text',
-                text: 'No active pager detected'
-              }
-            ]
-          };
+  async snapshotAllPanes({ lines = 20 } = {}) {
+    await this.ensureInitialized();
+
+    const window = (await this.tmux.listAllPanes())
+      .find(session => session.name === this.tmux.currentSession)?.windows
+      .find(w => String(w.index) === String(this.tmux.currentWindow));
+    const panes = window?.panes || [];
+
+    const snapshots = await Promise.all(panes.map(async pane => {
+      const [content, control] = await Promise.all([
+        this.tmux.capturePane(pane.index).catch(error => `(capture failed: ${error.message})`),
+        this.tmux.getPaneControl(pane.index).catch(() => 'unknown')
+      ]);
+      const tracked = [...this.activeCommands.entries()]
+        .find(([, info]) => String(info.paneIndex) === String(pane.index) && (this.isCommandActive(info) || info.status === 'queued'));
+
+      return {
+        pane,
+        control,
+        tracked,
+        content: content.split('\n').slice(-lines).join('\n')
+      };
+    }));
+
+    const sections = snapshots.map(({ pane, control, tracked, content }) => {
+      const labels = [
+        pane.index === this.tmux.ctPane ? 'CT Pane' : null,
+        String(pane.index) === String(this.tmux.currentPane) ? 'Claude' : null
+      ].filter(Boolean);
+      const trackedText = tracked ? `\n   Tracked: ${tracked[1].command} (${tracked[1].status}, ID ${tracked[0]})` : '';
+      return `── Pane ${pane.index}${labels.length ? ` [${labels.join(', ')}]` : ''} · ${pane.command} · ${control} ──${trackedText}\n${content}`;
+    });
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: `🖼️ ${panes.length} pane(s) in ${this.tmux.currentSession}:${this.tmux.currentWindow}:\n\n${sections.join('\n\n')}`
         }
+      ]
+    };
+  }
 
-        const actionsText = info.suggestedActions.map(action => `- ${action}`).join('\n');
-        const keysText = Object.entries(info.commonKeys)
-          .map(([key, desc]) => `  ${key}: ${desc}`)
-          .join('\n');
+  /**
+   * Follow a pane's output independently of any command. Watches are keyed by
+   * pane ID so renumbering after a split or kill doesn't move them.
+   */
+  async watchPane({ action, target_pane = null, interval = 2 }) {
+    await this.ensureInitialized();
 
-        const infoText = `Active Pager: ${info.command}
-Alternate Screen: ${info.alternateScreen ? 'Yes' : 'No'}
-Confidence: ${info.confidence}
+    if (action === 'list') {
+      await this.refreshWatchIndexes();
+      const watches = this.watcher.list();
+      const lines = watches.map(watch =>
+        `- Pane ${watch.paneIndex} (${watch.paneId}): every ${watch.intervalMs / 1000}s for ${Math.round((Date.now() - watch.startedAt) / 1000)}s, ` +
+        `${watch.buffer.length} unread line(s)${watch.error ? `, stopped: ${watch.error}` : ''}`
+      );
+      return {
+        content: [
+          {
+            type: 'text',
+            text: watches.length > 0 ? `👀 Active watches:\n${lines.join('\n')}` : '👀 No panes are being watched.'
+          }
+        ]
+      };
+    }
 
-Suggested Actions:
-${actionsText}
+    const paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
+          }
+        ]
+      };
+    }
 
-Common Keys:
-${keysText}`;
+    if (action === 'watch') {
+      const paneId = await this.tmux.getPaneId(paneIndex);
+      const watch = await this.watcher.watch(paneId, paneIndex, interval * 1000);
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `👀 Watching pane ${paneIndex} (${paneId}) every ${watch.intervalMs / 1000}s. Use watch_pane read to get new output, unwatch to stop.`
+          }
+        ]
+      };
+    }
 
-        return {
-          content: [
-            {
-              type: 'text',
-              text: infoText
-            }
-          ]
-        };
-      } catch (error) {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: `Failed to get pager info: ${error.message}`
-            }
-          ]
-        };
-      }
+    const watch = await this.findWatch(paneIndex);
+    if (!watch) {
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `❓ Pane ${paneIndex} is not being watched. Start with watch_pane watch.`
+          }
+        ]
+      };
     }
 
-    await this.ensureInitialized();
+    if (action === 'unwatch') {
+      this.watcher.unwatch(watch.paneId);
+      return {
+        content: [
+          {
+            type: 'text',
+            text: `🛑 Stopped watching pane ${paneIndex}.`
+          }
+        ]
+      };
+    }
 
-    try {
-      const info = await this.tmux.getPagerInfo(target_pane);
-      
-      if (!info.active) {
-        return {
-          content: [
-            {
-              type: 'text',
-              text: 'No active pager detected'
-            }
-          ]
-        };
-      }
+    const { seq, lines, dropped, redraws, fired, error } = this.watcher.read(watch.paneId);
+    const notes = [
+      ...fired.map(event => this.formatTriggerEvent(event)),
+      dropped > 0 ? `⚠️ ${dropped} older line(s) dropped; read more often` : null,
+      redraws > 0 ? `🔄 Screen redrawn ${redraws} time(s); redrawn screens are included in full` : null,
+      error ? `❌ Watch stopped: ${error}` : null
+    ].filter(Boolean);
+    return {
+      content: [
+        {
+          type: 'text',
+          text: `👀 Pane ${paneIndex} 🔢 Seq: ${seq}\n` +
+                (lines.length > 0 ? `📋 New output:\n${lines.join('\n')}` : '📋 No new output') +
+                (notes.length > 0 ? `\n\n${notes.join('\n')}` : '')
+        }
+      ]
+    };
+  }
 
-      const actionsText = info.suggestedActions.map(action => `- ${action}`).join('\n');
-      const keysText = Object.entries(info.commonKeys)
-        .map(([key, desc]) => `  ${key}: ${desc}`)
-        .join('\n');
+  /**
+   * The watch on a pane, looked up by pane ID since indexes shift when panes
+   * are split or closed. A closed pane's watch can still be named by its ID.
+   */
+  async findWatch(target) {
+    const paneId = await this.tmux.getPaneId(target).catch(() => String(target));
+    return this.watcher.watches.get(paneId) || null;
+  }
 
-      const infoText = `Active Pager: ${info.command}
-Alternate Screen: ${info.alternateScreen ? 'Yes' : 'No'}
-Confidence: ${info.confidence}
+  /**
+   * Re-read each watch's pane index from its pane ID; the index recorded when
+   * the watch started goes stale once panes are split or closed
+   */
+  async refreshWatchIndexes() {
+    await Promise.all(this.watcher.list().map(async watch => {
+      watch.paneIndex = await this.tmux.getPaneIndex(watch.paneId).catch(() => watch.paneIndex);
+    }));
+  }
 
-Suggested Actions:
-${actionsText}
+  /**
+   * Register, remove or list output triggers. Triggers ride on the pane's watch,
+   * which is started on the first add.
+   */
+  async manageTrigger({ action, target_pane = null, name = null, pattern = null, ignore_case = false, context_lines = 3 }) {
+    await this.ensureInitialized();
 
-Common Keys:
-${keysText}`;
+    if (action === 'list') {
+      await this.refreshWatchIndexes();
+      const lines = this.watcher.list().flatMap(watch => watch.triggers.map(trigger =>
+        `- Pane ${watch.paneIndex}: ${trigger.id} /${trigger.pattern.source}/${trigger.pattern.flags} fired ${trigger.fireCount} time(s)` +
+        (trigger.lastFiredAt ? `, last at ${new Date(trigger.lastFiredAt).toISOString()}` : '')
+      ));
+      return {
+        content: [
+          {
+            type: 'text',
+            text: lines.length > 0 ? `🎯 Output triggers:\n${lines.join('\n')}` : '🎯 No output triggers registered.'
+          }
+        ]
+      };
+    }
 
+    const paneIndex = target_pane || this.tmux.ctPane;
+    if (!paneIndex) {
       return {
         content: [
           {
             type: 'text',
-            text: infoText
+            text: '📋 No target pane specified and no default Claude Terminal pane available. Use create_claude_terminal to create one first.'
           }
         ]
       };
-    } catch (error) {
+    }
+
+    if (action === 'remove') {
+      const watch = await this.findWatch(paneIndex);
+      const removed = watch ? this.watcher.removeTrigger(watch.paneId, name ?? pattern) : false;
       return {
         content: [
           {
             type: 'text',
-            text: `Failed to get pager info: ${error.message}`
+            text: removed ? `🗑️ Removed trigger ${name ?? pattern} from pane ${paneIndex}.` : `❓ No trigger ${name ?? pattern} on pane ${paneIndex}.`
           }
         ]
       };
     }
+
+    if (!pattern) {
+      throw new Error('pattern is required to add a trigger');
+    }
+    let compiled;
+    try {
+      compiled = new RegExp(pattern, ignore_case ? 'i' : '');
+    } catch (error) {
+      throw new Error(`Invalid trigger pattern: ${error.message}`);
+    }
+
+    const paneId = await this.tmux.getPaneId(paneIndex);
+    const watch = this.watcher.watches.get(paneId) || await this.watcher.watch(paneId, paneIndex, 2000);
+    const trigger = this.watcher.addTrigger(paneId, { id: name ?? pattern, pattern: compiled, contextLines: context_lines });
+
+    return {
+      content: [
+        {
+          type: 'text',
+          text: `🎯 Trigger ${trigger.id} armed on pane ${paneIndex} (polling every ${watch.intervalMs / 1000}s). Matches show up in watch_pane read.`
+        }
+      ]
+    };
+  }
+
+  formatTriggerEvent(event) {
+    return `🎯 Trigger ${event.trigger} fired at ${new Date(event.firedAt).toISOString()}: ${event.line}\n` +
+      event.context.map(line => `   │ ${line}`).join('\n');
   }
 
   /**
-   * Send keys to active pager
+   * Warn in the result, and with a repeated_failure notification, that a command
+   * nearly repeats one that failed within the retry window
    */
-  async sendPagerKeys({ keys, target_pane = null }) {
+  flagRepeatedFailure(commandId, command, repeat, execution) {
+    const minutes = Math.max(1, Math.round((Date.now() - new Date(repeat.started_at).getTime()) / 60000));
+    execution.repeatOf = repeat.id;
+    execution.warnings.push(`Nearly identical to a command that ${repeat.status} ${minutes} min ago (${repeat.id}). Last time: ${repeat.hint}`);
+    console.error(`🔁 Repeat of failed command ${repeat.id}: ${this.redactor.redact(command)}`);
+    Promise.resolve().then(() => this.server.sendLoggingMessage({
+      level: 'warning',
+      logger: 'tmux-terminal-mcp',
+      data: this.redactor.redactDeep({
+        event: 'repeated_failure',
+        command_id: commandId,
+        command,
+        previous: { id: repeat.id, command: repeat.command, status: repeat.status, exit_code: repeat.exit_code, started_at: repeat.started_at },
+        hint: repeat.hint
+      })
+    })).catch(() => {
+      // Not connected or client ignores logging
+    });
+  }
+
+  /**
+   * Push a trigger_fired notification so clients that surface log messages see
+   * the match without polling
+   */
+  notifyTrigger(event) {
+    console.error(`🎯 Trigger ${event.trigger} fired in pane ${event.paneIndex}: ${this.redactor.redact(event.line)}`);
+    Promise.resolve().then(() => this.server.sendLoggingMessage({
+      level: 'notice',
+      logger: 'tmux-terminal-mcp',
+      data: this.redactor.redactDeep({ event: 'trigger_fired', ...event, firedAt: new Date(event.firedAt).toISOString() })
+    })).catch(() => {
+      // Not connected or client ignores logging
+    });
+  }
+
+  /**
+   * List every session/window/pane on the tmux server
+   */
+  async listTmuxSessions() {
     await this.ensureInitialized();
 
     try {
-      // First check if pager is active
-      const isPagerActive = await this.tmux.isPagerActive(target_pane);
-      if (!isPagerActive) {
-        return {
-          content: [
-            {
-              type: 'text', 
-              text: 'No active pager detected. Cannot send keys.'
-            }
-          ]
-        };
-      }
+      const sessions = await this.tmux.listAllPanes();
+      const current = `${this.tmux.currentSession}:${this.tmux.currentWindow}`;
 
-      // Send keys to pager
-      const result = await this.tmux.sendPagerKeys(keys, target_pane);
-      
       return {
         content: [
           {
             type: 'text',
-            text: `Keys "${keys}" sent to pager in pane ${result.pane}`
+            text: `🗂️ tmux sessions (target_pane addresses panes in ${current}, CT Pane: ${this.tmux.ctPane || 'none'}):\n\n` +
+                  JSON.stringify(sessions, null, 2)
           }
         ]
       };
@@ -988,14 +3410,109 @@ ${keysText}`;
         content: [
           {
             type: 'text',
-            text: `Failed to send keys to pager: ${error.message}`
+            text: `❌ ${error.message}`
           }
         ]
       };
     }
   }
 
+  /**
+   * Stop taking new commands, give running ones up to drainTimeout seconds to
+   * finish, tell the client what was left behind, then exit. A second signal
+   * exits immediately.
+   */
+  async shutdown(signal) {
+    if (this.shuttingDown) {
+      process.exit(1);
+    }
+    this.shuttingDown = true;
+    console.error(`⏹️ ${signal} received; draining running commands (up to ${this.drainTimeout}s)`);
+
+    // Queued commands never started, so there is nothing to wait for
+    for (const [commandId, info] of this.activeCommands) {
+      if (info.status === 'queued') {
+        this.paneQueue.remove(info.paneIndex, commandId);
+        info.status = 'cancelled';
+        info.error = 'Server shut down before the command started';
+      }
+    }
+
+    // Expedite pending polls now that the interval is shortened
+    const active = () => [...this.activeCommands.values()].filter(info => this.isCommandActive(info));
+    for (const info of active()) {
+      if (info.monitor) {
+        clearTimeout(info.monitorTimer);
+        info.monitorTimer = setTimeout(info.monitor, 0);
+      }
+    }
+
+    // Synchronous tool calls get to finish too, so their callers still get a result
+    const deadline = Date.now() + this.drainTimeout * 1000;
+    while ((active().length > 0 || this.pendingWaits.size > 0) && Date.now() < deadline) {
+      await new Promise(resolve => setTimeout(resolve, 250));
+    }
+
+    // Whatever is still going keeps running in its pane; only tracking stops
+    const abandoned = [];
+    for (const [commandId, info] of this.activeCommands) {
+      if (this.isCommandActive(info)) {
+        clearTimeout(info.monitorTimer);
+        info.status = 'interrupted';
+        info.error = 'Server shut down before the command finished; it may still be running in its pane';
+        this.recordHistory(commandId, info.command, {
+          status: 'interrupted',
+          startedAt: info.startTime,
+          persistence: info.persistence,
+          execution: info.execution
+        });
+        abandoned.push({ command_id: commandId, pane: info.paneIndex });
+      }
+    }
+    for (const [commandId, wait] of this.pendingWaits) {
+      this.recordHistory(commandId, wait.command, {
+        status: 'interrupted',
+        startedAt: wait.startTime,
+        persistence: wait.persistence,
+        execution: wait.execution
+      });
+      abandoned.push({ command_id: commandId, pane: wait.paneIndex });
+    }
+
+    const reason = abandoned.length === 0 ? 'drained' : 'drain_timeout';
+    this.audit('server_shutdown', { signal, reason, abandoned });
+    try {
+      await this.server.sendLoggingMessage({
+        level: abandoned.length === 0 ? 'info' : 'warning',
+        logger: 'tmux-terminal-mcp',
+        data: { event: 'server_shutdown', signal, reason, abandoned }
+      });
+    } catch (error) {
+      // Client already disconnected
+    }
+
+    console.error(`⏹️ Shutdown ${reason}${abandoned.length ? ` (${abandoned.length} command(s) still in their panes)` : ''}`);
+    process.exit(0);
+  }
+
   async run() {
+    this.outputBudget = await OutputBudget.create();
+
+    // The scratch directory lives as long as the conversation (this process)
+    process.on('exit', () => {
+      this.scratch.cleanup();
+      this.tmux.journal.close();
+    });
+    for (const signal of ['SIGINT', 'SIGTERM', 'SIGHUP']) {
+      process.on(signal, () => this.shutdown(signal));
+    }
+    this.server.oninitialized = () => this.audit('connection');
+    // The client is gone, so there is nobody left to drain for
+    this.server.onclose = () => {
+      this.audit('disconnect');
+      process.exit(0);
+    };
+
     const transport = new StdioServerTransport();
     await this.server.connect(transport);
     
@@ -1008,6 +3525,14 @@ ${keysText}`;
   }
 }
 
-// Run the server
-const server = new TmuxTerminalMCP();
-server.run().catch(console.error);
\ No newline at end of file
+// Only start when run directly (also via the npm bin symlink), not when imported by tests
+const isMain = process.argv[1] && realpathSync(process.argv[1]) === fileURLToPath(import.meta.url);
+
+// `--audit` prints a security posture report instead of starting the server
+if (isMain && process.argv.includes('--audit')) {
+  new SecurityAudit().run().then(report => console.log(report)).catch(console.error);
+} else if (isMain) {
+  // Run the server
+  const server = new TmuxTerminalMCP();
+  server.run().catch(console.error);
+}
\ No newline at end of file
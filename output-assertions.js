@@ -0,0 +1,53 @@
+/**
+ * Output Assertions - Evaluates declarative expectations against command results
+ */
+
+export class OutputAssertions {
+  /**
+   * Check `expect` ({contains, not_contains, exit_code}) against a result.
+   * contains/not_contains accept a string or an array of strings.
+   */
+  evaluate(expect, { output = '', exitCode = null } = {}) {
+    const failures = [];
+
+    if (!expect) {
+      return { passed: true, failures };
+    }
+
+    for (const text of this.toList(expect.contains)) {
+      if (!output.includes(text)) {
+        failures.push(`expected output to contain "${text}"`);
+      }
+    }
+
+    for (const text of this.toList(expect.not_contains)) {
+      if (output.includes(text)) {
+        failures.push(`expected output not to contain "${text}"`);
+      }
+    }
+
+    if (expect.exit_code !== undefined && expect.exit_code !== null) {
+      if (exitCode === null) {
+        failures.push(`expected exit code ${expect.exit_code} but it could not be determined`);
+      } else if (exitCode !== expect.exit_code) {
+        failures.push(`expected exit code ${expect.exit_code}, got ${exitCode}`);
+      }
+    }
+
+    return { passed: failures.length === 0, failures };
+  }
+
+  /**
+   * Whether the expectation needs the exit code sentinel to be evaluated
+   */
+  needsExitCode(expect) {
+    return expect?.exit_code !== undefined && expect?.exit_code !== null;
+  }
+
+  toList(value) {
+    if (value === undefined || value === null) {
+      return [];
+    }
+    return Array.isArray(value) ? value : [value];
+  }
+}
@@ -0,0 +1,102 @@
+/**
+ * Output Budget - Trims command output to a token budget for LLM consumers
+ */
+
+/**
+ * Fallback tokenizer: roughly 4 characters per token for English text and code
+ */
+export const approximateTokenizer = {
+  name: 'approximate',
+  count(text) {
+    return Math.ceil((text || '').length / 4);
+  }
+};
+
+export class OutputBudget {
+  constructor(tokenizer = approximateTokenizer) {
+    this.tokenizer = tokenizer;
+  }
+
+  /**
+   * Use a tiktoken-compatible tokenizer (js-tiktoken) when it's installed,
+   * falling back to the approximate counter otherwise
+   */
+  static async create(encoding = process.env.TMUX_MCP_TOKENIZER_ENCODING || 'cl100k_base') {
+    try {
+      const { getEncoding } = await import('js-tiktoken');
+      const encoder = getEncoding(encoding);
+      return new OutputBudget({
+        name: encoding,
+        count: text => encoder.encode(text || '').length
+      });
+    } catch (error) {
+      return new OutputBudget();
+    }
+  }
+
+  countTokens(text) {
+    return this.tokenizer.count(text);
+  }
+
+  /**
+   * Keep the last lines of output that fit in maxTokens, counting the
+   * truncation header; the tail of a command's output (errors, summaries) is
+   * usually what matters. A last line bigger than the whole budget is cut down
+   * to its tail rather than dropped.
+   */
+  truncate(text, maxTokens) {
+    const originalTokens = this.countTokens(text);
+    if (!maxTokens || originalTokens <= maxTokens) {
+      return { text, tokens: originalTokens, originalTokens, truncated: false };
+    }
+
+    const lines = text.split('\n');
+    const header = (omitted, cut) =>
+      `[... ${omitted} earlier lines omitted${cut ? ' and the last line cut' : ''} to fit ${maxTokens} tokens ...]\n`;
+    // Reserve room for the longest header this call could produce
+    const budget = Math.max(0, maxTokens - this.countTokens(header(lines.length, true)));
+    const kept = [];
+    let tokens = 0;
+
+    for (let i = lines.length - 1; i >= 0; i--) {
+      const lineTokens = this.countTokens(lines[i] + '\n');
+      if (tokens + lineTokens > budget) {
+        break;
+      }
+      kept.unshift(lines[i]);
+      tokens += lineTokens;
+    }
+
+    let cut = false;
+    if (kept.length === 0 && budget > 0) {
+      kept.push(this.tail(lines[lines.length - 1], budget));
+      cut = true;
+    }
+
+    const omitted = lines.length - (cut ? 1 : kept.length);
+    const result = header(omitted, cut) + kept.join('\n');
+    return {
+      text: result,
+      tokens: this.countTokens(result),
+      originalTokens,
+      truncated: true
+    };
+  }
+
+  /**
+   * Longest suffix of `line` that fits in maxTokens
+   */
+  tail(line, maxTokens) {
+    let low = 0;
+    let high = line.length;
+    while (low < high) {
+      const length = Math.ceil((low + high) / 2);
+      if (this.countTokens(line.slice(-length)) <= maxTokens) {
+        low = length;
+      } else {
+        high = length - 1;
+      }
+    }
+    return low > 0 ? line.slice(-low) : '';
+  }
+}
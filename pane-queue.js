@@ -0,0 +1,83 @@
+/**
+ * Pane Queue - Serializes commands per pane so concurrent executes don't interleave keys
+ */
+
+export class PaneQueue {
+  constructor() {
+    this.panes = new Map();
+  }
+
+  state(paneIndex) {
+    if (!this.panes.has(paneIndex)) {
+      this.panes.set(paneIndex, { active: null, waiting: [] });
+    }
+    return this.panes.get(paneIndex);
+  }
+
+  /**
+   * Whether a command currently owns the pane
+   */
+  isBusy(paneIndex) {
+    return this.state(paneIndex).active !== null;
+  }
+
+  /**
+   * Claim a free pane for a command
+   */
+  acquire(paneIndex, commandId) {
+    const state = this.state(paneIndex);
+    if (state.active !== null) {
+      throw new Error(`Pane ${paneIndex} is busy with command ${state.active}`);
+    }
+    state.active = commandId;
+  }
+
+  /**
+   * Queue a command behind the active one; `start` is called when it reaches the front.
+   * Returns the 1-based queue position.
+   */
+  enqueue(paneIndex, commandId, start) {
+    const state = this.state(paneIndex);
+    state.waiting.push({ commandId, start });
+    return state.waiting.length;
+  }
+
+  /**
+   * 1-based position of a waiting command, or null if it isn't waiting
+   */
+  position(paneIndex, commandId) {
+    const index = this.state(paneIndex).waiting.findIndex(job => job.commandId === commandId);
+    return index === -1 ? null : index + 1;
+  }
+
+  /**
+   * Drop a waiting command (e.g. cancelled before it started)
+   */
+  remove(paneIndex, commandId) {
+    const state = this.state(paneIndex);
+    const index = state.waiting.findIndex(job => job.commandId === commandId);
+    if (index === -1) {
+      return false;
+    }
+    state.waiting.splice(index, 1);
+    return true;
+  }
+
+  /**
+   * Release the pane if this command owns it and start the next waiting one.
+   * Safe to call more than once for the same command.
+   */
+  release(paneIndex, commandId) {
+    const state = this.state(paneIndex);
+    if (state.active !== commandId) {
+      return;
+    }
+
+    state.active = null;
+    const next = state.waiting.shift();
+    if (next) {
+      state.active = next.commandId;
+      next.start();
+    }
+  }
+}
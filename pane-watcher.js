@@ -0,0 +1,248 @@
+/**
+ * Pane Watcher - Polls panes for new output independently of any executed command
+ */
+
+const MAX_BUFFERED_LINES = 2000;
+const MAX_FIRED_EVENTS = 100;
+// Lines of the previous capture kept to diff against; the screen is far smaller
+const MAX_SNAPSHOT_LINES = 1000;
+
+/**
+ * Length of the longest suffix of `before` that `after` starts with
+ */
+function overlapLength(before, after) {
+  for (let overlap = Math.min(before.length, after.length); overlap > 0; overlap--) {
+    let matches = true;
+    for (let i = 0; i < overlap; i++) {
+      if (before[before.length - overlap + i] !== after[i]) {
+        matches = false;
+        break;
+      }
+    }
+    if (matches) {
+      return overlap;
+    }
+  }
+  return 0;
+}
+
+/**
+ * Lines in `next` that weren't on screen in `prev`. Output normally scrolls, so
+ * the longest suffix of prev that starts next is treated as already seen. The
+ * last line of prev may still have been in progress (a prompt being typed at,
+ * a progress bar), so a second attempt ignores it. Without any overlap, and
+ * unless the first line just grew, the screen was redrawn (clear, full-screen
+ * program) and all of it is new.
+ */
+export function diffSnapshots(prev, next) {
+  if (prev === next) {
+    return { lines: [], redrawn: false };
+  }
+
+  const before = prev ? prev.split('\n') : [];
+  const after = next ? next.split('\n') : [];
+
+  const overlap = overlapLength(before, after);
+  if (overlap > 0) {
+    return { lines: after.slice(overlap), redrawn: false };
+  }
+
+  const settled = overlapLength(before.slice(0, -1), after);
+  const continued = (after[settled] ?? '').startsWith(before[before.length - 1]);
+  return { lines: after.slice(settled), redrawn: settled === 0 && !continued && before.length > 0 };
+}
+
+export class PaneWatcher {
+  /**
+   * `capture(paneId, scrollbackLines)` returns the pane's screen text preceded
+   * by that many lines of scrollback; `historyInfo(paneId)` returns its
+   * { historySize, historyLimit }, so output that scrolled off the screen
+   * between polls is still seen. `onTrigger(event)` is called whenever a
+   * trigger matches a new line.
+   */
+  constructor(capture, onTrigger = null, historyInfo = null) {
+    this.capture = capture;
+    this.onTrigger = onTrigger;
+    this.historyInfo = historyInfo;
+    this.watches = new Map();
+  }
+
+  /**
+   * Start polling a pane every `intervalMs`; watching an already watched pane
+   * just updates the interval
+   */
+  async watch(paneId, paneIndex, intervalMs) {
+    const existing = this.watches.get(paneId);
+    if (existing) {
+      existing.intervalMs = intervalMs;
+      return existing;
+    }
+
+    const historySize = this.historyInfo ? (await this.historyInfo(paneId)).historySize : null;
+    const watch = {
+      paneId,
+      paneIndex,
+      intervalMs,
+      startedAt: Date.now(),
+      historySize,
+      snapshot: await this.capture(paneId, 0),
+      buffer: [],
+      dropped: 0,
+      redraws: 0,
+      seq: 0,
+      triggers: [],
+      fired: [],
+      error: null,
+      timer: null
+    };
+    this.watches.set(paneId, watch);
+    this.schedule(watch);
+    return watch;
+  }
+
+  schedule(watch) {
+    watch.timer = setTimeout(() => this.poll(watch), watch.intervalMs);
+    // A watch alone must not keep the server process alive
+    watch.timer.unref?.();
+  }
+
+  async poll(watch) {
+    if (this.watches.get(watch.paneId) !== watch) {
+      return;
+    }
+
+    try {
+      // Lines that entered the scrollback since the last poll scrolled past the
+      // screen; capture them too, above the screen, so the diff sees them.
+      // Once the history is full it stops growing and only the screen is diffed.
+      let scrolled = 0;
+      if (this.historyInfo) {
+        const { historySize, historyLimit } = await this.historyInfo(watch.paneId);
+        if (watch.historySize !== null) {
+          scrolled = Math.min(Math.max(historySize - watch.historySize, 0), historyLimit);
+        }
+        watch.historySize = historySize;
+      }
+
+      const next = await this.capture(watch.paneId, scrolled);
+      const { lines, redrawn } = diffSnapshots(watch.snapshot, next);
+      const nextLines = next ? next.split('\n') : [];
+      watch.snapshot = nextLines.slice(-MAX_SNAPSHOT_LINES).join('\n');
+      if (redrawn) {
+        watch.redraws++;
+      }
+      this.checkTriggers(watch, nextLines, lines.length);
+      watch.buffer.push(...lines);
+      if (watch.buffer.length > MAX_BUFFERED_LINES) {
+        watch.dropped += watch.buffer.length - MAX_BUFFERED_LINES;
+        watch.buffer.splice(0, watch.buffer.length - MAX_BUFFERED_LINES);
+      }
+      this.schedule(watch);
+    } catch (error) {
+      // Pane closed or server gone: keep the watch so its last output can still be read
+      watch.error = error.message;
+    }
+  }
+
+  /**
+   * Register a trigger on a watched pane. `pattern` is a RegExp; each match on a
+   * newly appeared line fires with `contextLines` lines either side of it.
+   */
+  addTrigger(paneId, { id, pattern, contextLines = 3 }) {
+    const watch = this.watches.get(paneId);
+    if (!watch) {
+      throw new Error(`Pane ${paneId} is not being watched`);
+    }
+    const trigger = { id, pattern, contextLines, fireCount: 0, lastFiredAt: null };
+    watch.triggers = watch.triggers.filter(existing => existing.id !== id).concat(trigger);
+    return trigger;
+  }
+
+  removeTrigger(paneId, id) {
+    const watch = this.watches.get(paneId);
+    if (!watch) {
+      return false;
+    }
+    const before = watch.triggers.length;
+    watch.triggers = watch.triggers.filter(trigger => trigger.id !== id);
+    return watch.triggers.length < before;
+  }
+
+  /**
+   * Match triggers against the last `newCount` lines of the screen; only lines
+   * that just appeared can fire, so a match sitting on screen fires once
+   */
+  checkTriggers(watch, screen, newCount) {
+    if (watch.triggers.length === 0 || newCount === 0) {
+      return;
+    }
+
+    for (let index = screen.length - newCount; index < screen.length; index++) {
+      for (const trigger of watch.triggers) {
+        if (!trigger.pattern.test(screen[index])) {
+          continue;
+        }
+
+        trigger.fireCount++;
+        trigger.lastFiredAt = Date.now();
+        const event = {
+          trigger: trigger.id,
+          paneId: watch.paneId,
+          paneIndex: watch.paneIndex,
+          line: screen[index],
+          context: screen.slice(Math.max(0, index - trigger.contextLines), index + trigger.contextLines + 1),
+          firedAt: trigger.lastFiredAt
+        };
+        watch.fired.push(event);
+        if (watch.fired.length > MAX_FIRED_EVENTS) {
+          watch.fired.shift();
+        }
+        this.onTrigger?.(event);
+      }
+    }
+  }
+
+  /**
+   * Take the lines gathered since the previous read
+   */
+  read(paneId) {
+    const watch = this.watches.get(paneId);
+    if (!watch) {
+      return null;
+    }
+
+    const result = {
+      seq: ++watch.seq,
+      lines: watch.buffer,
+      dropped: watch.dropped,
+      redraws: watch.redraws,
+      fired: watch.fired,
+      error: watch.error
+    };
+    watch.buffer = [];
+    watch.fired = [];
+    watch.dropped = 0;
+    watch.redraws = 0;
+    return result;
+  }
+
+  unwatch(paneId) {
+    const watch = this.watches.get(paneId);
+    if (!watch) {
+      return false;
+    }
+    clearTimeout(watch.timer);
+    this.watches.delete(paneId);
+    return true;
+  }
+
+  list() {
+    return [...this.watches.values()];
+  }
+
+  stopAll() {
+    for (const paneId of [...this.watches.keys()]) {
+      this.unwatch(paneId);
+    }
+  }
+}
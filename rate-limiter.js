@@ -0,0 +1,49 @@
+/**
+ * Rate Limiter - Token bucket that caps how fast new commands can be started
+ */
+
+export class RateLimiter {
+  /**
+   * `perMinute` tokens are refilled each minute, up to `burst`; perMinute 0 disables limiting
+   */
+  constructor({
+    perMinute = parseInt(process.env.TMUX_MCP_RATE_LIMIT ?? '60', 10),
+    burst = parseInt(process.env.TMUX_MCP_RATE_BURST ?? '10', 10),
+    now = () => Date.now()
+  } = {}) {
+    this.perMinute = Number.isNaN(perMinute) ? 60 : perMinute;
+    this.burst = Math.max(1, Number.isNaN(burst) ? 10 : burst);
+    this.now = now;
+    this.tokens = this.burst;
+    this.updatedAt = this.now();
+  }
+
+  isEnabled() {
+    return this.perMinute > 0;
+  }
+
+  refill() {
+    const now = this.now();
+    this.tokens = Math.min(this.burst, this.tokens + (now - this.updatedAt) * this.perMinute / 60000);
+    this.updatedAt = now;
+  }
+
+  /**
+   * Take a token if one is available. Returns { allowed, retryAfter } with
+   * retryAfter in seconds when the bucket is empty.
+   */
+  take() {
+    if (!this.isEnabled()) {
+      return { allowed: true, retryAfter: 0 };
+    }
+
+    this.refill();
+    if (this.tokens >= 1) {
+      this.tokens -= 1;
+      return { allowed: true, retryAfter: 0 };
+    }
+
+    const retryAfter = Math.ceil((1 - this.tokens) * 60 / this.perMinute);
+    return { allowed: false, retryAfter };
+  }
+}
@@ -0,0 +1,90 @@
+/**
+ * Receipt Signer - Signs final command results so downstream systems can verify them
+ */
+import { createHash, createHmac, timingSafeEqual } from 'crypto';
+
+export class ReceiptSigner {
+  constructor(key = process.env.TMUX_MCP_SIGNING_KEY) {
+    this.key = key || null;
+  }
+
+  /**
+   * Signing is optional and only active when a key is configured
+   */
+  isEnabled() {
+    return this.key !== null;
+  }
+
+  /**
+   * Hash command output so receipts don't have to carry the output itself
+   */
+  hashOutput(output) {
+    return createHash('sha256').update(output || '').digest('hex');
+  }
+
+  /**
+   * Build a signed receipt for a finished command
+   */
+  sign({ commandId, command, output, exitCode = null, startedAt, completedAt }) {
+    if (!this.isEnabled()) {
+      return null;
+    }
+
+    const receipt = {
+      command_id: commandId,
+      command,
+      output_sha256: this.hashOutput(output),
+      exit_code: exitCode,
+      started_at: new Date(startedAt).toISOString(),
+      completed_at: new Date(completedAt).toISOString()
+    };
+
+    return {
+      ...receipt,
+      signature: this.computeSignature(receipt)
+    };
+  }
+
+  /**
+   * Verify a receipt signature, and optionally that it matches the given output
+   */
+  verify(receipt, output = null) {
+    if (!this.isEnabled()) {
+      return { valid: false, reason: 'No signing key configured' };
+    }
+
+    if (!receipt || typeof receipt.signature !== 'string') {
+      return { valid: false, reason: 'Receipt has no signature' };
+    }
+
+    const { signature, ...fields } = receipt;
+    const expected = Buffer.from(this.computeSignature(fields), 'hex');
+    const actual = Buffer.from(signature, 'hex');
+
+    if (expected.length !== actual.length || !timingSafeEqual(expected, actual)) {
+      return { valid: false, reason: 'Signature mismatch' };
+    }
+
+    if (output !== null && this.hashOutput(output) !== fields.output_sha256) {
+      return { valid: false, reason: 'Output does not match receipt hash' };
+    }
+
+    return { valid: true };
+  }
+
+  /**
+   * HMAC over a canonical (fixed key order) serialization of the receipt fields
+   */
+  computeSignature(fields) {
+    const canonical = JSON.stringify([
+      fields.command_id,
+      fields.command,
+      fields.output_sha256,
+      fields.exit_code,
+      fields.started_at,
+      fields.completed_at
+    ]);
+
+    return createHmac('sha256', this.key).update(canonical).digest('hex');
+  }
+}
@@ -0,0 +1,80 @@
+/**
+ * Retry Detector - Spots a command that nearly repeats one that failed a few minutes ago,
+ * so an agent stuck in a retry loop hears about it before burning another run
+ */
+
+const FAILED_STATUSES = new Set(['failed', 'assertion_failed', 'error']);
+
+export class RetryDetector {
+  constructor({
+    windowMinutes = parseInt(process.env.TMUX_MCP_RETRY_WINDOW) || 10,
+    threshold = 0.9
+  } = {}) {
+    this.windowMinutes = windowMinutes; // 0 turns the check off
+    this.threshold = threshold;
+  }
+
+  isEnabled() {
+    return this.windowMinutes > 0;
+  }
+
+  /**
+   * The most recent failed history entry from the window whose command is
+   * nearly identical to `command`, with a hint taken from how it failed, or null
+   */
+  findRepeat(command, entries, now = Date.now()) {
+    if (!this.isEnabled()) {
+      return null;
+    }
+
+    const cutoff = now - this.windowMinutes * 60 * 1000;
+    for (const entry of [...entries].reverse()) {
+      if (new Date(entry.started_at).getTime() < cutoff) {
+        break;
+      }
+      if (FAILED_STATUSES.has(entry.status) && this.similarity(command, entry.command) >= this.threshold) {
+        return { ...entry, hint: this.failureHint(entry) };
+      }
+    }
+    return null;
+  }
+
+  /**
+   * 1 for identical commands (ignoring runs of whitespace), falling towards 0
+   * with the edit distance
+   */
+  similarity(a, b) {
+    a = a.trim().replace(/\s+/g, ' ');
+    b = b.trim().replace(/\s+/g, ' ');
+    const longest = Math.max(a.length, b.length);
+    return longest === 0 ? 1 : 1 - this.editDistance(a, b) / longest;
+  }
+
+  editDistance(a, b) {
+    let previous = Array.from({ length: b.length + 1 }, (_, i) => i);
+    for (let i = 1; i <= a.length; i++) {
+      const current = [i];
+      for (let j = 1; j <= b.length; j++) {
+        current[j] = Math.min(
+          previous[j] + 1,
+          current[j - 1] + 1,
+          previous[j - 1] + (a[i - 1] === b[j - 1] ? 0 : 1)
+        );
+      }
+      previous = current;
+    }
+    return previous[b.length];
+  }
+
+  /**
+   * Last meaningful output line of the failure (usually the error), else its exit code
+   */
+  failureHint(entry) {
+    const lines = (entry.output || '').split('\n').map(line => line.trim())
+      .filter(line => line && !/^[$#>%]$/.test(line) && !line.startsWith(`$ ${entry.command}`));
+    if (lines.length > 0) {
+      return lines[lines.length - 1];
+    }
+    return entry.exit_code !== null && entry.exit_code !== undefined ? `exit code ${entry.exit_code}` : entry.status;
+  }
+}
@@ -0,0 +1,107 @@
+/**
+ * Runaway Detector - Spots pathological output (accidental `yes`, infinite loops)
+ */
+
+export class RunawayDetector {
+  constructor({
+    policy = process.env.TMUX_MCP_RUNAWAY_POLICY || 'warn',
+    maxLinesPerSecond = parseInt(process.env.TMUX_MCP_RUNAWAY_LINES_PER_SEC) || 500,
+    sustainSeconds = parseInt(process.env.TMUX_MCP_RUNAWAY_SECONDS) || 10,
+    repeatRatio = 0.9,
+    minRepeatLines = 20
+  } = {}) {
+    this.policy = policy; // 'warn', 'interrupt' or 'off'
+    this.maxLinesPerSecond = maxLinesPerSecond;
+    this.sustainSeconds = sustainSeconds;
+    this.repeatRatio = repeatRatio;
+    this.minRepeatLines = minRepeatLines;
+  }
+
+  isEnabled() {
+    return this.policy !== 'off';
+  }
+
+  /**
+   * Feed one capture sample for a command. `state` is a per-command object the
+   * caller keeps between samples. Returns an anomaly once the condition has
+   * held for sustainSeconds, and only once per command.
+   */
+  sample(state, { historySize, historyLimit, output, now = Date.now() }) {
+    if (!this.isEnabled() || state.fired) {
+      return null;
+    }
+
+    if (state.startTime === undefined) {
+      state.startTime = now;
+      state.startHistory = historySize;
+      state.lastTime = now;
+      state.lastHistory = historySize;
+      return null;
+    }
+
+    const reason = this.detectRepetition(output) || this.detectRate(state, historySize, historyLimit, now);
+
+    state.lastTime = now;
+    state.lastHistory = historySize;
+
+    if (!reason) {
+      state.since = undefined;
+      return null;
+    }
+
+    if (state.since === undefined) {
+      state.since = now;
+    }
+
+    if (now - state.since < this.sustainSeconds * 1000) {
+      return null;
+    }
+
+    state.fired = true;
+    return { reason, policy: this.policy };
+  }
+
+  /**
+   * Same line filling (nearly) the whole visible screen
+   */
+  detectRepetition(output) {
+    const lines = (output || '').split('\n').filter(line => line.trim() !== '');
+    if (lines.length < this.minRepeatLines) {
+      return null;
+    }
+
+    const counts = new Map();
+    for (const line of lines) {
+      counts.set(line, (counts.get(line) || 0) + 1);
+    }
+
+    const [line, count] = [...counts.entries()].reduce((a, b) => (b[1] > a[1] ? b : a));
+    if (count / lines.length >= this.repeatRatio) {
+      return `same line repeated ${count} times on screen: "${line.slice(0, 60)}"`;
+    }
+
+    return null;
+  }
+
+  /**
+   * Scrollback growing faster than the threshold. Once the scrollback is full
+   * its size stops changing, so hitting the limit counts as over the threshold.
+   */
+  detectRate(state, historySize, historyLimit, now) {
+    if (historyLimit && historySize >= historyLimit && state.startHistory < historyLimit) {
+      return `scrollback filled to its ${historyLimit} line limit`;
+    }
+
+    const elapsed = (now - state.lastTime) / 1000;
+    if (elapsed <= 0) {
+      return null;
+    }
+
+    const rate = (historySize - state.lastHistory) / elapsed;
+    if (rate > this.maxLinesPerSecond) {
+      return `output rate ${Math.round(rate)} lines/s exceeds ${this.maxLinesPerSecond} lines/s`;
+    }
+
+    return null;
+  }
+}
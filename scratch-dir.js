@@ -0,0 +1,41 @@
+/**
+ * Scratch Directory - Per-conversation temp directory exposed to commands as $BRIDGE_SCRATCH
+ */
+import { mkdtempSync, rmSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+
+export class ScratchDirectory {
+  constructor(baseDir = tmpdir()) {
+    this.baseDir = baseDir;
+    this.path = null;
+  }
+
+  /**
+   * Create the directory on first use
+   */
+  ensure() {
+    if (!this.path) {
+      this.path = mkdtempSync(join(this.baseDir, 'bridge-scratch-'));
+    }
+    return this.path;
+  }
+
+  /**
+   * Prefix a command with the export, so it holds in whichever pane or shell
+   * the command lands in, however panes were renumbered or respawned since
+   */
+  wrap(command) {
+    return `export BRIDGE_SCRATCH='${this.ensure()}'; ${command}`;
+  }
+
+  /**
+   * Remove the directory and everything in it; safe to call more than once
+   */
+  cleanup() {
+    if (this.path) {
+      rmSync(this.path, { recursive: true, force: true });
+      this.path = null;
+    }
+  }
+}
@@ -0,0 +1,91 @@
+/**
+ * Secret Redactor - Masks credentials in captured output before it is returned, logged or persisted
+ */
+
+/**
+ * Built-in patterns. When a pattern has a capture group, the group (e.g. the
+ * `password=` key) is kept and only the value is replaced.
+ */
+export const DEFAULT_PATTERNS = [
+  // PEM private key blocks
+  /-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----/g,
+  // AWS access key IDs
+  /\b(?:AKIA|ASIA)[0-9A-Z]{16}\b/g,
+  // GitHub and Slack tokens
+  /\b(?:gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,})\b/g,
+  // Authorization: Bearer <token>
+  /(\bBearer[ \t]+)[A-Za-z0-9\-._~+/]+=*/g,
+  // KEY=value / "key": "value" where the key names a password, secret, token or API key
+  // (bare numbers are left alone so counts like max_tokens: 2000 survive)
+  /(\b[A-Za-z_]*(?:password|passwd|secret|token|api[_-]?key)[A-Za-z_]*["']?[ \t]*[=:][ \t]*)(?!\d+\b)["']?[^\s"',;]+["']?/gi
+];
+
+export class SecretRedactor {
+  constructor({
+    enabled = !['0', 'false'].includes(process.env.TMUX_MCP_REDACT),
+    extraPatterns = process.env.TMUX_MCP_REDACT_PATTERNS
+  } = {}) {
+    this.enabled = enabled;
+    this.patterns = [...DEFAULT_PATTERNS, ...this.parsePatterns(extraPatterns)];
+  }
+
+  /**
+   * Extra patterns come as a JSON array of regex source strings; invalid ones are skipped
+   */
+  parsePatterns(value) {
+    if (!value) {
+      return [];
+    }
+
+    let sources;
+    try {
+      sources = JSON.parse(value);
+    } catch (error) {
+      console.error(`⚠️ TMUX_MCP_REDACT_PATTERNS is not a JSON array: ${error.message}`);
+      return [];
+    }
+
+    const patterns = [];
+    for (const source of Array.isArray(sources) ? sources : []) {
+      try {
+        patterns.push(new RegExp(source, 'g'));
+      } catch (error) {
+        console.error(`⚠️ Skipping invalid redaction pattern ${source}: ${error.message}`);
+      }
+    }
+    return patterns;
+  }
+
+  /**
+   * Replace every match with [REDACTED]; safe to apply more than once
+   */
+  redact(text) {
+    if (!this.enabled || typeof text !== 'string') {
+      return text;
+    }
+
+    let result = text;
+    for (const pattern of this.patterns) {
+      result = result.replace(pattern, (match, prefix) =>
+        typeof prefix === 'string' ? `${prefix}[REDACTED]` : '[REDACTED]'
+      );
+    }
+    return result;
+  }
+
+  /**
+   * Redact every string inside a JSON-like value (tool arguments, audit details)
+   */
+  redactDeep(value) {
+    if (typeof value === 'string') {
+      return this.redact(value);
+    }
+    if (Array.isArray(value)) {
+      return value.map(item => this.redactDeep(item));
+    }
+    if (value && typeof value === 'object') {
+      return Object.fromEntries(Object.entries(value).map(([key, item]) => [key, this.redactDeep(item)]));
+    }
+    return value;
+  }
+}
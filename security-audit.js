@@ -0,0 +1,157 @@
+/**
+ * Security Audit - Scores the server's configuration and environment for risky settings
+ */
+import { stat } from 'fs/promises';
+import { homedir } from 'os';
+import path from 'path';
+
+export class SecurityAudit {
+  constructor(env = process.env) {
+    this.env = env;
+  }
+
+  /**
+   * Socket of the server picked by TMUX_MCP_SOCKET_PATH/TMUX_MCP_SOCKET_NAME, if any
+   * (-L sockets live in tmux's per-user directory under TMUX_TMPDIR)
+   */
+  configuredSocket() {
+    if (this.env.TMUX_MCP_SOCKET_PATH) {
+      return path.resolve(this.env.TMUX_MCP_SOCKET_PATH);
+    }
+    if (this.env.TMUX_MCP_SOCKET_NAME && typeof process.getuid === 'function') {
+      return path.join(this.env.TMUX_TMPDIR || '/tmp', `tmux-${process.getuid()}`, this.env.TMUX_MCP_SOCKET_NAME);
+    }
+    return null;
+  }
+
+  /**
+   * Gather the facts the audit looks at
+   */
+  async collectFacts() {
+    const socketPath = this.configuredSocket() ?? (this.env.TMUX ? this.env.TMUX.split(',')[0] : null);
+    let socketMode = null;
+    let socketDirMode = null;
+
+    if (socketPath) {
+      try {
+        socketMode = (await stat(socketPath)).mode & 0o777;
+        socketDirMode = (await stat(path.dirname(socketPath))).mode & 0o777;
+      } catch (error) {
+        // Socket not readable; leave mode unknown
+      }
+    }
+
+    return {
+      uid: typeof process.getuid === 'function' ? process.getuid() : null,
+      home: homedir(),
+      socketPath,
+      socketMode,
+      socketDirMode,
+      fsRoots: this.env.TMUX_MCP_FS_ROOTS?.split(':').filter(Boolean) || [],
+      runawayPolicy: this.env.TMUX_MCP_RUNAWAY_POLICY || 'warn',
+      signingKey: this.env.TMUX_MCP_SIGNING_KEY || null,
+      redactionDisabled: ['0', 'false'].includes(this.env.TMUX_MCP_REDACT)
+    };
+  }
+
+  /**
+   * Turn facts into scored findings with remediation
+   */
+  evaluate(facts) {
+    const findings = [];
+
+    if (facts.uid === 0) {
+      findings.push({
+        severity: 'high',
+        penalty: 30,
+        issue: 'Server is running as root; every command Claude runs has full system access',
+        remediation: 'Run tmux and Claude as an unprivileged user'
+      });
+    }
+
+    if (!facts.socketPath) {
+      findings.push({
+        severity: 'medium',
+        penalty: 10,
+        issue: 'Not running inside tmux ($TMUX is unset)',
+        remediation: 'Start the MCP server from within a tmux session'
+      });
+    } else if (
+      facts.socketMode !== null && facts.socketDirMode !== null &&
+      (facts.socketDirMode & 0o077) !== 0 && (facts.socketMode & 0o066) !== 0
+    ) {
+      // tmux keeps its socket directory private; only flag when both are open
+      findings.push({
+        severity: 'high',
+        penalty: 25,
+        issue: `tmux socket ${facts.socketPath} is accessible by other users (socket ${facts.socketMode.toString(8)}, directory ${facts.socketDirMode.toString(8)})`,
+        remediation: `chmod 700 ${path.dirname(facts.socketPath)} so only you can drive the session`
+      });
+    }
+
+    for (const root of facts.fsRoots) {
+      const resolved = path.resolve(root);
+      if (resolved === '/' || resolved === path.resolve(facts.home)) {
+        findings.push({
+          severity: 'medium',
+          penalty: 15,
+          issue: `TMUX_MCP_FS_ROOTS includes ${resolved}, exposing far more than the project`,
+          remediation: 'Limit TMUX_MCP_FS_ROOTS to project directories'
+        });
+      }
+    }
+
+    if (facts.runawayPolicy === 'off') {
+      findings.push({
+        severity: 'low',
+        penalty: 5,
+        issue: 'Runaway output detection is disabled',
+        remediation: 'Set TMUX_MCP_RUNAWAY_POLICY=warn or interrupt'
+      });
+    }
+
+    if (facts.redactionDisabled) {
+      findings.push({
+        severity: 'medium',
+        penalty: 10,
+        issue: 'Secret redaction is disabled; credentials printed in the terminal reach the client and history file verbatim',
+        remediation: 'Unset TMUX_MCP_REDACT, adding TMUX_MCP_REDACT_PATTERNS for project-specific secrets instead'
+      });
+    }
+
+    if (facts.signingKey !== null && facts.signingKey.length < 16) {
+      findings.push({
+        severity: 'medium',
+        penalty: 10,
+        issue: 'TMUX_MCP_SIGNING_KEY is shorter than 16 characters; receipts are easy to forge',
+        remediation: 'Use a long random key, e.g. `openssl rand -hex 32`'
+      });
+    }
+
+    const score = Math.max(0, 100 - findings.reduce((sum, finding) => sum + finding.penalty, 0));
+    return { score, findings };
+  }
+
+  /**
+   * Format a report for the terminal
+   */
+  formatReport({ score, findings }) {
+    const lines = [`🔒 Security posture score: ${score}/100`, ''];
+
+    if (findings.length === 0) {
+      lines.push('✅ No risky settings found');
+      return lines.join('\n');
+    }
+
+    for (const finding of findings) {
+      lines.push(`[${finding.severity.toUpperCase()}] ${finding.issue}`);
+      lines.push(`   Fix: ${finding.remediation}`);
+    }
+
+    return lines.join('\n');
+  }
+
+  async run() {
+    return this.formatReport(this.evaluate(await this.collectFacts()));
+  }
+}
@@ -0,0 +1,271 @@
+/**
+ * Shared fixture for the server tests: a simulated tmux and a TmuxTerminalMCP
+ * wired to it. Importing it fails if `npm install` hasn't provided the MCP SDK,
+ * which is deliberate: the suite must not pass by skipping.
+ */
+
+import { mkdtempSync, rmSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { Server } from '@modelcontextprotocol/sdk/server/index.js';
+import { CallToolRequestSchema } from '@modelcontextprotocol/sdk/types.js';
+import { TmuxManager } from '../../tmux-manager.js';
+import { HistoryStore } from '../../history-store.js';
+import { TmuxTerminalMCP } from '../../mcp-server.js';
+
+/**
+ * TmuxManager whose panes are plain arrays of lines. Typed commands are echoed
+ * after a `$ ` prompt (`> ` for continuation lines) and answered by `respond`,
+ * which returns { output, exitCode }, or { output, hang: true } for a command
+ * that keeps running until finish() or an interrupt.
+ */
+export class FakeTmux extends TmuxManager {
+  constructor(respond = () => ({})) {
+    super('tmux');
+    this.respond = respond;
+    this.currentSession = 's';
+    this.currentWindow = '0';
+    this.currentPane = '0';
+    this.ctPane = 1;
+    this.panes = new Map();
+    this.nextId = 0;
+    this.sent = [];
+    this.approvalAnswer = 'approved';
+    this.approvalRequests = [];
+    for (const index of [0, 1, 2, 3]) {
+      this.addPane(index);
+    }
+  }
+
+  addPane(index) {
+    const pane = { index, id: `%${this.nextId++}`, lines: ['$'], running: null, width: 80, control: 'agent', group: null };
+    this.panes.set(String(index), pane);
+    return pane;
+  }
+
+  // Open a pane at `index`, shifting later panes up like tmux split-window does
+  insertPane(index) {
+    const later = [...this.panes.values()].filter(pane => pane.index >= index).sort((a, b) => b.index - a.index);
+    for (const pane of later) {
+      this.panes.delete(String(pane.index));
+      pane.index++;
+      this.panes.set(String(pane.index), pane);
+    }
+    return this.addPane(index);
+  }
+
+  pane(target) {
+    const key = String(target ?? this.ctPane);
+    const pane = key.startsWith('%') ? [...this.panes.values()].find(p => p.id === key) : this.panes.get(key);
+    if (!pane) {
+      throw new Error(`can't find pane ${key}`);
+    }
+    return pane;
+  }
+
+  finish(target, output = '', exitCode = 0) {
+    const pane = this.pane(target);
+    const { sentinelId } = pane.running;
+    pane.running = null;
+    pane.lines.push(...(output ? output.split('\n') : []));
+    if (sentinelId) {
+      pane.lines.push(`__BRIDGE_DONE_${sentinelId}_${exitCode}`);
+    }
+    pane.lines.push('$');
+  }
+
+  async sendKeys(text, pressEnter = false, targetPane = null, journaled = text) {
+    const pane = this.pane(targetPane);
+    this.journal.record(String(pane.index), pressEnter ? [journaled, 'C-m'] : [journaled]);
+    this.sent.push({ pane: pane.index, text });
+    if (pane.running) {
+      pane.running.input.push(text);
+      return;
+    }
+
+    const typed = text.split('\n');
+    pane.lines[pane.lines.length - 1] = `$ ${typed[0]}`;
+    pane.lines.push(...typed.slice(1).map(line => `> ${line}`));
+    if (!pressEnter) {
+      return;
+    }
+
+    const sentinelId = text.match(/echo __BRIDGE_DONE_(\w+)_\$\?/)?.[1] ?? null;
+    pane.running = { typed: text, sentinelId, input: [] };
+    const { output = '', exitCode = 0, hang = false } = this.respond(text, pane.index) || {};
+    if (!hang) {
+      this.finish(pane.index, output, exitCode);
+    } else if (output) {
+      pane.lines.push(...output.split('\n'));
+    }
+  }
+
+  async sendRawKeys(keys, literal = false, targetPane = null) {
+    const pane = this.pane(targetPane);
+    this.sent.push({ pane: pane.index, keys, literal });
+    pane.running?.input.push(keys);
+  }
+
+  async sendInterrupt(targetPane = null) {
+    const pane = this.pane(targetPane);
+    this.sent.push({ pane: pane.index, keys: 'C-c' });
+    if (pane.running) {
+      this.finish(pane.index, '^C', 130);
+    }
+  }
+
+  async capturePane(targetPane = null) {
+    return this.pane(targetPane).lines.join('\n');
+  }
+
+  async clearPane(targetPane = null) {
+    this.pane(targetPane).lines = ['$'];
+  }
+
+  async isCommandComplete(targetPane = null) {
+    return !this.pane(targetPane).running;
+  }
+
+  async getHistoryInfo() {
+    return { historySize: 0, historyLimit: 2000 };
+  }
+
+  async getPaneId(targetPane = null) {
+    return this.pane(targetPane).id;
+  }
+
+  async getPaneIndex(targetPane = null) {
+    return this.pane(targetPane).index;
+  }
+
+  async listPanes() {
+    return [...this.panes.values()].sort((a, b) => a.index - b.index).map(({ index, width }) => ({ index, width }));
+  }
+
+  async splitPane(targetPane) {
+    return this.insertPane(this.pane(targetPane).index + 1).index;
+  }
+
+  async getPaneWidth(targetPane = null) {
+    return this.pane(targetPane).width;
+  }
+
+  async resizePane(targetPane, { width = null } = {}) {
+    if (width) {
+      this.pane(targetPane).width = width;
+    }
+  }
+
+  async getPaneControl(targetPane = null) {
+    return this.pane(targetPane).control;
+  }
+
+  async setPaneControl(control, targetPane = null) {
+    this.pane(targetPane).control = control;
+  }
+
+  async getGroupPanes(group) {
+    return [...this.panes.values()].filter(pane => pane.group === group).map(pane => pane.index);
+  }
+
+  async promptRelease() {
+    this.releasePrompts = (this.releasePrompts || 0) + 1;
+  }
+
+  async requestApproval(command, approvalId, timeoutMs, targetPane = null) {
+    this.approvalRequests.push({ command, pane: targetPane });
+    return this.approvalAnswer;
+  }
+
+  async getPaneBranch() {
+    return 'main';
+  }
+
+  async getPaneShell() {
+    return 'bash';
+  }
+
+  async focusClaudeTerminal(targetPane = null) {
+    this.focused = targetPane ?? this.ctPane;
+  }
+}
+
+/**
+ * Server wired to a FakeTmux, with env overrides applied while it is constructed
+ */
+export function createServer({ respond, env = {} } = {}) {
+  const saved = {};
+  for (const [key, value] of Object.entries(env)) {
+    saved[key] = process.env[key];
+    process.env[key] = value;
+  }
+  try {
+    const mcp = new TmuxTerminalMCP();
+    mcp.tmux = new FakeTmux(respond);
+    mcp.isInitialized = true;
+    mcp.helpShown = true;
+    mcp.scratch.wrap = command => command;
+    servers.push(mcp);
+    return mcp;
+  } finally {
+    for (const [key, value] of Object.entries(saved)) {
+      if (value === undefined) {
+        delete process.env[key];
+      } else {
+        process.env[key] = value;
+      }
+    }
+  }
+}
+
+const servers = [];
+
+/**
+ * Stop the background monitors (10s timers) and pane watchers of every server
+ * created so far, so none outlive the test. Call from afterEach.
+ */
+export function stopServers() {
+  for (const mcp of servers.splice(0)) {
+    for (const info of mcp.activeCommands.values()) {
+      clearTimeout(info.monitorTimer);
+    }
+    mcp.watcher.stopAll();
+  }
+}
+
+/**
+ * Calls the server's registered CallTool handler, so the audit, help and
+ * policy wrapping around executeToolRequest run too. Call before createServer.
+ */
+export function captureCallTool(t) {
+  const handlers = new Map();
+  t.mock.method(Server.prototype, 'setRequestHandler', (schema, handler) => handlers.set(schema, handler));
+  return (name, args = {}) => handlers.get(CallToolRequestSchema)({ params: { name, arguments: args } });
+}
+
+/**
+ * Temporary directory removed when the test ends
+ */
+export function tempDir(t) {
+  const dir = mkdtempSync(join(tmpdir(), 'bridge-server-'));
+  t.after(() => rmSync(dir, { recursive: true, force: true }));
+  return dir;
+}
+
+/**
+ * Point the server's command history at a fresh file for this test
+ */
+export function useHistory(t, mcp) {
+  mcp.history = new HistoryStore(join(tempDir(t), 'history.jsonl'));
+  return mcp.history;
+}
+
+export const text = result => result.content.map(item => item.text).join('\n');
+export const commandIdOf = result => text(result).match(/Command ID: ([0-9a-f-]{36})/)[1];
+
+/**
+ * Run a tool and return its result text
+ */
+export async function runTool(mcp, name, args = {}) {
+  return text(await mcp.executeToolRequest(name, args));
+}
@@ -0,0 +1,188 @@
+#!/usr/bin/env node
+
+/**
+ * Integration tests driving the real tool handlers against a real tmux server
+ * (opt-in: TMUX_MCP_INTEGRATION=1)
+ *
+ * Each shell gets its own session on a private tmux server (TMUX_TMPDIR points
+ * tmux at a throwaway socket directory), so the user's sessions are never touched.
+ */
+
+import { test, before, after } from 'node:test';
+import { strict as assert } from 'node:assert';
+import { execFileSync } from 'child_process';
+import { mkdtempSync, rmSync } from 'fs';
+import { tmpdir } from 'os';
+import { join } from 'path';
+import { TmuxManager } from '../tmux-manager.js';
+import { TmuxTerminalMCP } from '../mcp-server.js';
+
+const enabled = ['1', 'true'].includes(process.env.TMUX_MCP_INTEGRATION);
+
+const SHELLS = [
+  { name: 'bash', command: 'bash --norc --noprofile' },
+  { name: 'zsh', command: 'zsh -f' }
+];
+
+function hasBinary(name) {
+  try {
+    execFileSync('which', [name], { stdio: 'ignore' });
+    return true;
+  } catch (error) {
+    return false;
+  }
+}
+
+async function waitFor(check, timeoutMs = 5000) {
+  const deadline = Date.now() + timeoutMs;
+  while (Date.now() < deadline) {
+    const result = await check();
+    if (result) {
+      return result;
+    }
+    await new Promise(resolve => setTimeout(resolve, 100));
+  }
+  throw new Error(`Timed out after ${timeoutMs}ms`);
+}
+
+/**
+ * Run a command through the real execute_terminal_command handler, waiting for
+ * the completion marker, and return the result text with the tracked result
+ */
+async function run(mcp, command, options = {}) {
+  const result = await mcp.executeToolRequest('execute_terminal_command', {
+    command,
+    wait_for_completion: true,
+    strict_completion: true,
+    ...options
+  });
+  const text = result.content.map(item => item.text).join('\n');
+  const commandId = text.match(/Command ID: (\S+)/)?.[1];
+  return { text, ...mcp.activeCommands.get(commandId) };
+}
+
+let socketDir = null;
+const savedEnv = {};
+
+before(() => {
+  if (!enabled) return;
+  socketDir = mkdtempSync(join(tmpdir(), 'bridge-it-'));
+  savedEnv.TMUX = process.env.TMUX;
+  savedEnv.TMUX_TMPDIR = process.env.TMUX_TMPDIR;
+  delete process.env.TMUX;
+  process.env.TMUX_TMPDIR = socketDir;
+});
+
+after(() => {
+  if (!enabled) return;
+  try {
+    execFileSync('tmux', ['kill-server'], { stdio: 'ignore' });
+  } catch (error) {
+    // Server already gone
+  }
+  for (const [key, value] of Object.entries(savedEnv)) {
+    if (value === undefined) {
+      delete process.env[key];
+    } else {
+      process.env[key] = value;
+    }
+  }
+  rmSync(socketDir, { recursive: true, force: true });
+});
+
+for (const shell of SHELLS) {
+  const skip = !enabled ? 'set TMUX_MCP_INTEGRATION=1 to run' :
+    (!hasBinary('tmux') || !hasBinary(shell.name)) ? `${shell.name} or tmux not installed` : false;
+
+  test(`Integration (${shell.name}) - execute and capture pipeline`, { skip }, async (t) => {
+    const session = `it-${shell.name}`;
+    execFileSync('tmux', ['new-session', '-d', '-s', session, '-x', '200', '-y', '50', shell.command]);
+
+    const tmux = new TmuxManager();
+    await tmux.ensureSession(session);
+    tmux.ctPane = tmux.currentPane;
+    await waitFor(async () => tmux.isCommandCompleteByOutput(await tmux.capturePane()));
+
+    const mcp = new TmuxTerminalMCP();
+    mcp.tmux = tmux;
+    mcp.isInitialized = true;
+    mcp.helpShown = true;
+
+    await t.test('reports the pane shell', async () => {
+      const name = await tmux.getPaneShell();
+      assert.equal(name, shell.name);
+      assert.ok(tmux.supportsSentinel(name));
+    });
+
+    await t.test('sentinel reports output and exit code', async () => {
+      const result = await run(mcp, "printf 'alpha\\nbeta\\n'; false");
+      assert.equal(result.status, 'failed');
+      assert.equal(result.exitCode, 1);
+      assert.ok(result.output.includes('beta'));
+      assert.ok(!result.text.includes('__BRIDGE_DONE_'));
+    });
+
+    await t.test('sentinel survives a trailing & or comment', async () => {
+      const background = await run(mcp, 'sleep 0.1 &');
+      assert.equal(background.exitCode, 0);
+      assert.ok(background.output.split('\n')[0].endsWith(' sleep 0.1 &'));
+
+      const commented = await run(mcp, 'echo commented # not the marker');
+      assert.equal(commented.exitCode, 0);
+      assert.ok(commented.output.includes('\ncommented'));
+      assert.ok(!commented.output.includes('}; echo'));
+    });
+
+    await t.test('first character survives clearPane', async () => {
+      const result = await run(mcp, 'echo first-char-ok');
+      assert.equal(result.exitCode, 0);
+      assert.ok(result.output.includes('\nfirst-char-ok'));
+    });
+
+    await t.test('env snapshot reports exported variables', async () => {
+      const result = await run(mcp, 'export BRIDGE_IT=1', { track_env: true });
+      assert.equal(result.exitCode, 0);
+      assert.equal(result.envDiff.added.BRIDGE_IT, '1');
+      assert.ok(!result.output.includes('bridge-env-'));
+    });
+
+    await t.test('scrollback capture keeps output that scrolled off screen', async () => {
+      const lines = (await run(mcp, 'seq 1 300')).output.split('\n');
+      assert.ok(lines.includes('1'));
+      assert.ok(lines.includes('300'));
+    });
+
+    await t.test('long lines are not hard-wrapped at the pane width', async () => {
+      const long = 'x'.repeat(450);
+      const result = await run(mcp, `echo ${long}`);
+      assert.ok(result.output.split('\n').includes(long));
+    });
+
+    await t.test('every command sees $BRIDGE_SCRATCH without the export in its output', async () => {
+      const result = await run(mcp, 'echo "scratch=$BRIDGE_SCRATCH"');
+      assert.ok(result.output.includes(`\nscratch=${mcp.scratch.path}`));
+      assert.ok(!result.output.includes('export BRIDGE_SCRATCH'));
+    });
+
+    await t.test('watcher sees output that scrolled past between polls', async () => {
+      const watch = await mcp.watcher.watch(await tmux.getPaneId(), tmux.ctPane, 60000);
+      await tmux.sendKeys('seq 1001 1200', true);
+      await waitFor(async () => (await tmux.capturePane()).split('\n').includes('1200'));
+      await mcp.watcher.poll(watch);
+      const lines = mcp.watcher.read(watch.paneId).lines;
+      mcp.watcher.unwatch(watch.paneId);
+      assert.ok(lines.includes('1001'));
+      assert.ok(lines.includes('1200'));
+    });
+
+    await t.test('fresh pane runs a command and is removed', async () => {
+      const before = (await tmux.listPanes()).length;
+      const result = await run(mcp, 'echo isolated', { fresh_pane: true });
+      assert.ok(result.output.includes('isolated'));
+      await waitFor(async () => (await tmux.listPanes()).length === before);
+    });
+
+    mcp.scratch.cleanup();
+    execFileSync('tmux', ['kill-session', '-t', session]);
+  });
+}
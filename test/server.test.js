@@ -0,0 +1,444 @@
+#!/usr/bin/env node
+
+/**
+ * Behaviour tests for the MCP tool handlers, driven through executeToolRequest
+ * against a simulated tmux (no tmux server needed). Needs `npm install` for the
+ * MCP SDK; without it the suite fails rather than skipping.
+ */
+
+import { test, afterEach } from 'node:test';
+import { strict as assert } from 'node:assert';
+import { readFileSync } from 'fs';
+import { join } from 'path';
+import { AuditLog } from '../audit-log.js';
+import { KeysJournal } from '../keys-journal.js';
+import { captureCallTool, commandIdOf, createServer, runTool, stopServers, tempDir, text, useHistory } from './helpers/server-fixture.js';
+
+afterEach(stopServers);
+
+test('Server - quick command returns output and exit code', async () => {
+  const mcp = createServer({ respond: () => ({ output: 'hello', exitCode: 3 }) });
+  const result = await mcp.executeToolRequest('execute_terminal_command', { command: 'echo hello', detect_exit_code: true });
+
+  assert.match(text(result), /failed in .*exit code 3/);
+  assert.match(text(result), /\nhello\n/);
+  assert.equal(mcp.paneQueue.isBusy(1), false);
+});
+
+test('Server - assertions ignore the echoed command line', async () => {
+  const mcp = createServer({ respond: () => ({ output: 'FAIL 3 tests' }) });
+
+  const grep = await runTool(mcp, 'execute_terminal_command', { command: 'npm test | grep PASS', expect: { contains: 'PASS' } });
+  assert.match(grep, /assertion_failed/);
+
+  const flag = await runTool(mcp, 'execute_terminal_command', { command: 'deploy --dry-run', expect: { not_contains: 'dry-run', exit_code: 0 } });
+  assert.match(flag, /completed in .*\(assertions passed\)/);
+  assert.ok(flag.includes('$ deploy --dry-run\nFAIL 3 tests'));
+});
+
+test('Server - complete_when does not match the typed command', async () => {
+  const mcp = createServer({ respond: typed => ({ output: typed.includes('8000') ? '' : 'Listening on 3000', hang: true }) });
+
+  const silent = await runTool(mcp, 'execute_terminal_command', { command: 'python -m http.server 8000', complete_when: { regex: '8000', timeout: 1 } });
+  assert.ok(!silent.includes('ready in'));
+
+  const ready = text(await createServer({ respond: mcp.tmux.respond }).executeToolRequest('execute_terminal_command', { command: 'node server.js', complete_when: { regex: 'Listening on \\d+', timeout: 5 } }));
+  assert.match(ready, /ready in .*still running in background/);
+});
+
+test('Server - consumed readiness keeps the pane reserved and redacts its output', async () => {
+  const mcp = createServer({ respond: () => ({ output: 'API_TOKEN=swordfish\nListening on 3000', hang: true }) });
+  const result = await mcp.executeToolRequest('execute_terminal_command', { command: 'node server.js', complete_when: { regex: 'Listening on \\d+', consume: true, timeout: 5 } });
+  assert.match(text(result), /ready in .*pane 1 stays reserved/);
+  assert.ok(!text(result).includes('swordfish'));
+  assert.equal(mcp.paneQueue.isBusy(1), true);
+
+  await mcp.executeToolRequest('cancel_command', { command_id: commandIdOf(result) });
+  assert.equal(mcp.paneQueue.isBusy(1), false);
+});
+
+test('Server - ephemeral commands stay out of the audit log and keys journal', async (t) => {
+  const callTool = captureCallTool(t);
+
+  const mcp = createServer({ respond: () => ({ output: 'ok' }) });
+  mcp.helpShown = true;
+  const dir = tempDir(t);
+  mcp.auditLog = new AuditLog(join(dir, 'audit.jsonl'));
+  mcp.tmux.journal = new KeysJournal(join(dir, 'keys.jsonl'));
+
+  await callTool('execute_terminal_command', { command: 'vault login s3cr3t', persistence: 'ephemeral' });
+  mcp.tmux.journal.close();
+
+  const audit = readFileSync(join(dir, 'audit.jsonl'), 'utf8');
+  const keys = readFileSync(join(dir, 'keys.jsonl'), 'utf8');
+  assert.match(audit, /\[ephemeral command\]/);
+  assert.ok(!audit.includes('vault login') && !keys.includes('vault login'));
+  assert.match(keys, /\[ephemeral command\]/);
+});
+
+test('Server - a near repeat of a recent failure is flagged', async (t) => {
+  const mcp = createServer({ respond: () => ({ output: "ls: cannot access 'dist': No such file or directory", exitCode: 2 }) });
+  const history = useHistory(t, mcp);
+  const events = [];
+  t.mock.method(mcp.server, 'sendLoggingMessage', async message => events.push(message.data));
+
+  await runTool(mcp, 'execute_terminal_command', { command: 'ls dist', detect_exit_code: true });
+  const retry = await runTool(mcp, 'execute_terminal_command', { command: 'ls  dist', detect_exit_code: true });
+  assert.match(retry, /Nearly identical to a command that failed .*Last time: ls: cannot access 'dist'/);
+  assert.equal(history.query().at(-1).repeat_of, history.query()[0].id);
+  assert.deepEqual(events.map(event => event.event), ['repeated_failure']);
+});
+
+test('Server - get_usage_stats summarizes the recorded history', async (t) => {
+  const mcp = createServer({ respond: typed => ({ output: 'x', exitCode: typed.includes('false') ? 1 : 0 }) });
+  assert.match(await runTool(mcp, 'get_usage_stats'), /not being recorded/);
+
+  useHistory(t, mcp);
+  for (const command of ['git status', 'git status', 'false']) {
+    await runTool(mcp, 'execute_terminal_command', { command, detect_exit_code: true });
+  }
+  const stats = await runTool(mcp, 'get_usage_stats');
+  assert.match(stats, /Usage over 3 command\(s\)/);
+  assert.match(stats, /Success rate: 66\.7%/);
+  assert.match(stats, /pane 1: 3/);
+  assert.match(stats, /2× git status/);
+});
+
+test('Server - redact_history scrubs history and audit entries and keeps a tombstone', async (t) => {
+  const callTool = captureCallTool(t);
+  const mcp = createServer({ respond: () => ({ output: 'hunter2' }) });
+  const history = useHistory(t, mcp);
+  const auditPath = join(tempDir(t), 'audit.jsonl');
+  mcp.auditLog = new AuditLog(auditPath);
+
+  const id = commandIdOf(await callTool('execute_terminal_command', { command: 'echo hunter2' }));
+  const result = text(await callTool('redact_history', { command_id: id, reason: 'password on screen' }));
+  assert.match(result, /Redacted command .* and 1 audit log entry/);
+
+  assert.equal(history.find(id).command, '[REDACTED]');
+  assert.equal(history.find(id).tombstone.reason, 'password on screen');
+  assert.equal(mcp.activeCommands.has(id), false);
+  const audit = readFileSync(auditPath, 'utf8').trim().split('\n').map(line => JSON.parse(line));
+  assert.ok(!JSON.stringify(audit).includes('hunter2'));
+  assert.deepEqual([audit.at(-1).event, audit.at(-1).command_id, audit.at(-1).audit_entries], ['history_redacted', id, 1]);
+  assert.match(await runTool(mcp, 'get_command_history'), /🧹 Redacted .*: password on screen/);
+
+  const missing = await mcp.executeToolRequest('redact_history', { command_id: 'nope', reason: 'x' });
+  assert.equal(missing.isError, true);
+});
+
+test('Server - strict_completion refuses shells without the marker as an error', async () => {
+  const mcp = createServer({ respond: () => ({ output: 'ok' }) });
+  mcp.tmux.getPaneShell = async () => 'fish';
+
+  const result = await mcp.executeToolRequest('execute_terminal_command', { command: 'ls', strict_completion: true });
+  assert.equal(result.isError, true);
+  assert.match(text(result), /needs a POSIX shell in pane 1, but it is running fish/);
+  assert.equal(mcp.tmux.sent.length, 0);
+});
+
+test('Server - interactive programs focus and name the pane they run in', async () => {
+  const mcp = createServer();
+  const result = await runTool(mcp, 'execute_terminal_command', { command: 'node', target_pane: 2 });
+  assert.match(result, /Focus switched to pane 2\./);
+  assert.equal(mcp.tmux.focused, 2);
+});
+
+test('Server - send_keys and send_command_input share the rate limit', async () => {
+  const mcp = createServer({ env: { TMUX_MCP_RATE_LIMIT: '1', TMUX_MCP_RATE_BURST: '2' } });
+
+  assert.match(await runTool(mcp, 'send_keys', { keys: 'Up' }), /Sent keys/);
+  assert.doesNotMatch(await runTool(mcp, 'send_command_input', { command_id: 'missing', input: 'y' }), /rate_limited/);
+  const refused = await mcp.executeToolRequest('send_keys', { keys: 'Enter' });
+  assert.equal(refused.isError, true);
+  assert.match(text(refused), /rate_limited/);
+});
+
+test('Server - receipts still verify after secrets are redacted', async () => {
+  const mcp = createServer({ env: { TMUX_MCP_SIGNING_KEY: 'k' }, respond: () => ({ output: 'deployed' }) });
+  const result = mcp.redactResult(await mcp.executeToolRequest('execute_terminal_command', { command: 'API_TOKEN=swordfish make deploy' }));
+  assert.ok(!text(result).includes('swordfish'));
+
+  const receipt = text(result).match(/🧾 Receipt: (.*)/)[1];
+  assert.equal(JSON.parse(receipt).command, 'API_TOKEN=[REDACTED] make deploy');
+  assert.match(await runTool(mcp, 'verify_receipt', { receipt }), /is valid/);
+});
+
+test('Server - approval mode holds commands and typed keys until approved', async () => {
+  const mcp = createServer({ env: { TMUX_MCP_REQUIRE_APPROVAL: '1' }, respond: () => ({ output: 'ran' }) });
+
+  mcp.tmux.approvalAnswer = 'denied';
+  assert.equal((await mcp.executeToolRequest('execute_terminal_command', { command: 'rm -rf build' })).isError, true);
+  assert.equal((await mcp.executeToolRequest('send_keys', { keys: 'rm -rf build\n', literal: true })).isError, true);
+  assert.equal(mcp.tmux.sent.length, 0);
+  assert.deepEqual(mcp.tmux.approvalRequests.map(request => request.command), ['rm -rf build', 'text "rm -rf build\n"']);
+
+  mcp.tmux.approvalAnswer = 'approved';
+  assert.match(await runTool(mcp, 'execute_terminal_command', { command: 'make' }), /completed/);
+  assert.equal(mcp.tmux.sent.length, 1);
+});
+
+test('Server - shutdown waits for and reports every unfinished command', async (t) => {
+  const mcp = createServer({ env: { TMUX_MCP_DRAIN_TIMEOUT: '1' }, respond: () => ({ hang: true }) });
+  useHistory(t, mcp);
+  t.mock.method(process, 'exit', () => {});
+
+  const prompt = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'npm login', wait_for_completion: false }));
+  mcp.activeCommands.get(prompt).status = 'needs_interaction';
+  const waiting = mcp.executeToolRequest('execute_terminal_command', { command: 'curl example.com', target_pane: '2' });
+  await new Promise(resolve => setImmediate(resolve));
+  assert.equal(mcp.pendingWaits.size, 1);
+
+  await mcp.shutdown('SIGTERM');
+  const { data } = mcp.server.logs.at(-1);
+  assert.equal(data.reason, 'drain_timeout');
+  assert.deepEqual(data.abandoned.map(entry => String(entry.pane)).sort(), ['1', '2']);
+  assert.deepEqual(mcp.history.query({ status: 'interrupted' }).map(entry => entry.command).sort(), ['curl example.com', 'npm login']);
+
+  mcp.tmux.finish(2);
+  await waiting;
+});
+
+test('Server - persistence levels control what get_command_status keeps', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+
+  const metadata = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'make secret', wait_for_completion: false, persistence: 'metadata_only' }));
+  mcp.tmux.finish(1, 'classified', 0);
+  await mcp.activeCommands.get(metadata).monitor();
+  const metadataStatus = await runTool(mcp, 'get_command_status', { command_id: metadata });
+  assert.match(metadataStatus, /Status: completed/);
+  assert.ok(!metadataStatus.includes('classified'));
+
+  // Waiting on input is still in flight: polling must not forget it
+  const prompt = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'npm login', wait_for_completion: false, persistence: 'ephemeral' }));
+  mcp.activeCommands.get(prompt).status = 'needs_interaction';
+  await mcp.executeToolRequest('get_command_status', { command_id: prompt });
+  assert.equal(mcp.activeCommands.get(prompt).status, 'needs_interaction');
+  await mcp.executeToolRequest('send_command_input', { command_id: prompt, input: 'me' });
+  mcp.tmux.finish(1);
+  await mcp.activeCommands.get(prompt).monitor();
+  assert.equal(mcp.paneQueue.isBusy(1), false);
+
+  const ephemeral = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'make once', wait_for_completion: false, persistence: 'ephemeral' }));
+  mcp.tmux.finish(1, 'only-once', 0);
+  await mcp.activeCommands.get(ephemeral).monitor();
+  assert.match(await runTool(mcp, 'get_command_status', { command_id: ephemeral }), /only-once/);
+  assert.match(await runTool(mcp, 'get_command_status', { command_id: ephemeral }), /not found/);
+});
+
+test('Server - cancel_command interrupts the pane and frees it', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  const id = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'sleep 100', wait_for_completion: false }));
+  assert.equal(mcp.paneQueue.isBusy(1), true);
+
+  assert.match(await runTool(mcp, 'cancel_command', { command_id: id }), /cancelled/);
+  assert.deepEqual(mcp.tmux.sent.at(-1), { pane: 1, keys: 'C-c' });
+  assert.equal(mcp.activeCommands.get(id).status, 'cancelled');
+  assert.equal(mcp.paneQueue.isBusy(1), false);
+});
+
+test('Server - send_command_input types into the command and resumes monitoring', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  const id = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'npm init', wait_for_completion: false }));
+  mcp.activeCommands.get(id).status = 'needs_interaction';
+
+  await mcp.executeToolRequest('send_command_input', { command_id: id, input: 'yes' });
+  assert.deepEqual(mcp.tmux.pane(1).running.input, ['yes', 'Enter']);
+  assert.equal(mcp.activeCommands.get(id).status, 'running');
+});
+
+test('Server - delta output only returns lines not delivered before', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  const id = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'make', wait_for_completion: false }));
+
+  mcp.tmux.pane(1).lines.push('step 1');
+  const first = await runTool(mcp, 'get_command_status', { command_id: id, delta: true });
+  mcp.tmux.pane(1).lines.push('step 2');
+  const second = await runTool(mcp, 'get_command_status', { command_id: id, delta: true });
+
+  assert.match(first, /Seq: 1/);
+  assert.ok(first.includes('step 1'));
+  assert.match(second, /Seq: 2/);
+  assert.ok(second.includes('step 2'));
+  assert.ok(!second.includes('step 1'));
+});
+
+test('Server - TMUX_MCP_DISABLE_EXECUTE hides and refuses free-form tools', async () => {
+  const mcp = createServer({ env: { TMUX_MCP_DISABLE_EXECUTE: '1' } });
+  const names = mcp.availableTools([{ name: 'execute_terminal_command' }, { name: 'send_keys' }, { name: 'get_command_status' }]).map(tool => tool.name);
+  assert.deepEqual(names, ['get_command_status']);
+
+  const result = await mcp.executeToolRequest('execute_terminal_command', { command: 'ls' });
+  assert.equal(result.isError, true);
+  assert.equal(mcp.tmux.sent.length, 0);
+});
+
+test('Server - broadcast_input runs in every pane and reports each exit code', async () => {
+  const mcp = createServer({ respond: (typed, pane) => ({ output: `host${pane}`, exitCode: pane === 3 ? 1 : 0 }) });
+  mcp.tmux.pane(2).group = 'web';
+  mcp.tmux.pane(3).group = 'web';
+
+  const result = await runTool(mcp, 'broadcast_input', { command: 'hostname', group: 'web' });
+  assert.match(result, /── Pane 2 ──\n✅ exit code 0/);
+  assert.match(result, /── Pane 3 ──\n❌ exit code 1/);
+  assert.ok(result.includes('host3'));
+  assert.equal(mcp.paneQueue.isBusy(2) || mcp.paneQueue.isBusy(3), false);
+});
+
+test('Server - broadcast_input hands timed-out panes to background monitoring', async (t) => {
+  const mcp = createServer({ respond: (typed, pane) => ({ output: `host${pane}`, hang: String(pane) === '3' }) });
+  useHistory(t, mcp);
+  mcp.tmux.getPaneShell = async target => String(target) === '2' ? 'fish' : 'bash';
+
+  const result = await runTool(mcp, 'broadcast_input', { command: 'hostname', panes: [2, 3], timeout: 1 });
+  assert.match(result, /── Pane 2 ──\n✅ finished \(exit code unknown\)/);
+  assert.ok(!mcp.tmux.sent.find(entry => entry.pane === 2).text.includes('__BRIDGE_DONE_'));
+  const id = result.match(/Command ID: ([\w-]+)\)/)[1];
+  assert.equal(mcp.paneQueue.isBusy(3), true);
+  assert.deepEqual(mcp.history.query().map(entry => String(entry.pane)), ['2']);
+
+  mcp.tmux.finish(3, 'host3', 0);
+  await mcp.activeCommands.get(id).monitor();
+  assert.equal(mcp.paneQueue.isBusy(3), false);
+  assert.deepEqual(mcp.history.query().map(entry => [String(entry.pane), entry.status]), [['2', 'completed'], ['3', 'completed']]);
+});
+
+test('Server - pane_width resizes for the command and restores the width after', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  const id = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'cargo build', wait_for_completion: false, pane_width: 200 }));
+  assert.equal(mcp.tmux.pane(1).width, 200);
+
+  mcp.tmux.finish(1, 'done', 0);
+  await mcp.activeCommands.get(id).monitor();
+  await new Promise(resolve => setImmediate(resolve));
+  assert.equal(mcp.tmux.pane(1).width, 80);
+});
+
+test('Server - a command whose pane was replaced fails with PANE_CHANGED', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  const id = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'make', wait_for_completion: false }));
+
+  mcp.tmux.pane(1).id = '%99';
+  await mcp.activeCommands.get(id).monitor();
+  const info = mcp.activeCommands.get(id);
+  assert.equal(info.status, 'error');
+  assert.match(info.error, /PANE_CHANGED/);
+  assert.equal(mcp.paneQueue.isBusy(1), false);
+});
+
+test('Server - watch_pane follows the pane after it is renumbered', async () => {
+  const mcp = createServer();
+  await mcp.executeToolRequest('watch_pane', { action: 'watch', target_pane: '2' });
+  mcp.tmux.pane(2).lines.push('compiled');
+  await mcp.watcher.poll(mcp.watcher.list()[0]);
+
+  mcp.tmux.insertPane(2);
+  assert.match(await runTool(mcp, 'watch_pane', { action: 'read', target_pane: '2' }), /not being watched/);
+  assert.match(await runTool(mcp, 'watch_pane', { action: 'read', target_pane: '3' }), /New output:\ncompiled/);
+  assert.match(await runTool(mcp, 'watch_pane', { action: 'unwatch', target_pane: '3' }), /Stopped watching/);
+  assert.equal(mcp.watcher.list().length, 0);
+});
+
+test('Server - manage_trigger removes triggers on a renumbered pane', async () => {
+  const mcp = createServer();
+  await mcp.executeToolRequest('manage_trigger', { action: 'add', target_pane: '2', name: 'err', pattern: 'ERROR' });
+
+  mcp.tmux.insertPane(1);
+  assert.match(await runTool(mcp, 'manage_trigger', { action: 'remove', target_pane: '2', name: 'err' }), /No trigger err/);
+  assert.match(await runTool(mcp, 'manage_trigger', { action: 'remove', target_pane: '3', name: 'err' }), /Removed trigger err/);
+});
+
+test('Server - watch and trigger lists show the current pane index', async () => {
+  const mcp = createServer();
+  await mcp.executeToolRequest('manage_trigger', { action: 'add', target_pane: '2', name: 'err', pattern: 'ERROR' });
+
+  mcp.tmux.insertPane(1);
+  assert.match(await runTool(mcp, 'watch_pane', { action: 'list' }), /- Pane 3 \(%2\)/);
+  assert.match(await runTool(mcp, 'manage_trigger', { action: 'list' }), /- Pane 3: err/);
+});
+
+test('Server - a human-controlled pane refuses agent input until the human releases it', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  const id = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'tail -f app.log', wait_for_completion: false }));
+  mcp.tmux.pane(1).control = 'human';
+  const sent = mcp.tmux.sent.length;
+
+  assert.match(await runTool(mcp, 'cancel_command', { command_id: id }), /under human control/);
+  assert.match(await runTool(mcp, 'send_keys', { keys: 'Escape' }), /under human control/);
+  assert.match(await runTool(mcp, 'send_pager_keys', { keys: 'q' }), /under human control/);
+  assert.equal(mcp.tmux.sent.length, sent);
+
+  // release only asks; the pane stays with the human until they answer y
+  assert.match(await runTool(mcp, 'handoff_pane', { action: 'release' }), /asked in tmux/);
+  assert.equal(mcp.tmux.releasePrompts, 1);
+  assert.equal(mcp.tmux.pane(1).control, 'human');
+});
+
+test('Server - interactive programs are refused while the pane is busy', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  await mcp.executeToolRequest('execute_terminal_command', { command: 'npm run build', wait_for_completion: false });
+  const sent = mcp.tmux.sent.length;
+
+  const result = await mcp.executeToolRequest('execute_terminal_command', { command: 'vim notes' });
+  assert.equal(result.isError, true);
+  assert.match(text(result), /running a tracked command/);
+  assert.equal(mcp.tmux.sent.length, sent);
+
+  assert.match(await runTool(mcp, 'execute_terminal_command', { command: 'vim notes', target_pane: '2' }), /Focus switched/);
+  assert.equal(mcp.tmux.sent.at(-1).pane, 2);
+});
+
+test('Server - broadcasts and interactive programs are written to history', async (t) => {
+  const mcp = createServer({ respond: (typed, pane) => ({ output: `host${pane}` }) });
+  useHistory(t, mcp);
+
+  await mcp.executeToolRequest('broadcast_input', { command: 'hostname', panes: ['2', '3'] });
+  await mcp.executeToolRequest('execute_terminal_command', { command: 'node', target_pane: '1' });
+
+  const entries = mcp.history.query();
+  assert.deepEqual(entries.map(entry => [entry.command, String(entry.pane), entry.status]), [
+    ['hostname', '2', 'completed'],
+    ['hostname', '3', 'completed'],
+    ['node', '1', 'interactive']
+  ]);
+  assert.equal(entries[0].output, '$ hostname\nhost2\n$');
+});
+
+test('Server - manage_pane refuses a split that would renumber a busy pane', async () => {
+  const mcp = createServer({ respond: () => ({ hang: true }) });
+  await mcp.executeToolRequest('execute_terminal_command', { command: 'npm run build', target_pane: '2', wait_for_completion: false });
+
+  assert.match(await runTool(mcp, 'manage_pane', { action: 'split', target_pane: '1' }), /Pane\(s\) 2 are running tracked commands/);
+  assert.equal(mcp.tmux.panes.size, 4);
+
+  assert.match(await runTool(mcp, 'manage_pane', { action: 'split', target_pane: '3' }), /new pane is 4/);
+});
+
+test('Server - the first tool call keeps isError when help is prepended', async (t) => {
+  const callTool = captureCallTool(t);
+
+  const mcp = createServer({ env: { TMUX_MCP_DISABLE_EXECUTE: '1' } });
+  mcp.helpShown = false;
+  const result = await callTool('execute_terminal_command', { command: 'ls' });
+  assert.equal(result.isError, true);
+  assert.ok(result.content.length > 1);
+});
+
+test('Server - results carry their command ID and keep the original start time', async () => {
+  const mcp = createServer({ respond: typed => typed.includes('sleep') ? { hang: true } : { output: 'ok' } });
+
+  const quick = await mcp.executeToolRequest('execute_terminal_command', { command: 'git status' });
+  const quickId = commandIdOf(quick);
+  assert.match(await runTool(mcp, 'get_command_status', { command_id: quickId }), /Status: completed[\s\S]*ok/);
+
+  const first = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'sleep 100', wait_for_completion: false }));
+  const queued = commandIdOf(await mcp.executeToolRequest('execute_terminal_command', { command: 'sleep 200' }));
+  const queuedAt = mcp.activeCommands.get(queued).startTime;
+  await new Promise(resolve => setTimeout(resolve, 20));
+
+  mcp.tmux.finish(1);
+  await mcp.activeCommands.get(first).monitor();
+  await new Promise(resolve => setTimeout(resolve, 20));
+  assert.equal(mcp.activeCommands.get(queued).status, 'running');
+  assert.equal(mcp.activeCommands.get(queued).startTime, queuedAt);
+});
@@ -3,17 +3,52 @@
  */
 import { exec, spawn } from 'child_process';
 import { promisify } from 'util';
+import { readFile, unlink } from 'fs/promises';
 import path from 'path';
+import { KeysJournal } from './keys-journal.js';
 
 const execAsync = promisify(exec);
 
+/**
+ * The tmux invocation every command starts with: TMUX_MCP_TMUX_BIN picks the
+ * binary, TMUX_MCP_SOCKET_PATH (-S) or TMUX_MCP_SOCKET_NAME (-L) the server
+ */
+export function tmuxInvocation(env = process.env) {
+  const quote = value => `'${String(value).replace(/'/g, "'\"'\"'")}'`;
+  const parts = [env.TMUX_MCP_TMUX_BIN ? quote(env.TMUX_MCP_TMUX_BIN) : 'tmux'];
+  if (env.TMUX_MCP_SOCKET_PATH) {
+    parts.push('-S', quote(env.TMUX_MCP_SOCKET_PATH));
+  } else if (env.TMUX_MCP_SOCKET_NAME) {
+    parts.push('-L', quote(env.TMUX_MCP_SOCKET_NAME));
+  }
+  return parts.join(' ');
+}
+
+// Escape-stripping patterns, compiled once rather than on every capture
+const OSC_SEQUENCE = /\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)/g;
+const STRING_SEQUENCE = /\x1b[PX^_][^\x1b]*\x1b\\/g;
+const CSI_SEQUENCE = /\x1b\[[0-?]*[ -/]*[@-~]/g;
+const SHORT_ESCAPE = /\x1b[ -/]*[0-~]/g;
+const CONTROL_CHARS = /[\x00-\x08\x0B-\x1f\x7f-\x9f]/g;
+const CARRIAGE_RETURN = /\r/g;
+const TRAILING_WHITESPACE = /[^\S\n]+$/gm;
+
+// Scrollback captures of multi-megabyte build logs overflow exec's 1MB default
+const CAPTURE_MAX_BUFFER = 64 * 1024 * 1024;
+
+// Panes whose last capture is cached; the oldest is dropped past this
+const MAX_CACHED_CAPTURES = 64;
+
 export class TmuxManager {
-  constructor() {
+  constructor(bin = tmuxInvocation()) {
+    this.bin = bin;
     this.currentSession = null;
     this.currentWindow = null;
     this.currentPane = null;
     this.ctPane = null; // Claude Terminal Pane
     this.runningCommands = new Map();
+    this.journal = new KeysJournal();
+    this.lastCaptures = new Map(); // target -> { raw, clean }, for polling unchanged panes
   }
 
   /**
@@ -21,7 +56,7 @@ export class TmuxManager {
    */
   async detectTmuxEnvironment() {
     try {
-      const { stdout } = await execAsync('tmux display-message -p "#S:#I.#P"');
+      const { stdout } = await execAsync(`${this.bin} display-message -p "#S:#I.#P"`);
       const [session, windowPane] = stdout.trim().split(':');
       const [window, pane] = windowPane.split('.');
       this.currentSession = session;
@@ -39,13 +74,52 @@ export class TmuxManager {
     }
   }
 
+  /**
+   * Create a detached session (or reuse it if it already exists) and make it current.
+   * Returns true when a new session was created.
+   */
+  async ensureSession(name) {
+    let created = false;
+    try {
+      await execAsync(`${this.bin} has-session -t ${this.shellQuote(name)}`);
+    } catch (error) {
+      await execAsync(`${this.bin} new-session -d -s ${this.shellQuote(name)} -c ${this.shellQuote(process.cwd())}`);
+      created = true;
+    }
+
+    const { stdout } = await execAsync(`${this.bin} display-message -p -t ${this.shellQuote(name)} "#S:#I.#P"`);
+    const [session, windowPane] = stdout.trim().split(':');
+    const [window, pane] = windowPane.split('.');
+    this.currentSession = session;
+    this.currentWindow = window;
+    this.currentPane = pane;
+
+    return created;
+  }
+
+  /**
+   * Whether the current session still exists
+   */
+  async hasSession() {
+    if (!this.currentSession) {
+      return false;
+    }
+    try {
+      await execAsync(`${this.bin} has-session -t ${this.shellQuote(this.currentSession)}`);
+      return true;
+    } catch (error) {
+      return false;
+    }
+  }
+
   /**
    * List all panes in current window with detailed info
    */
   async listPanes() {
     try {
+      const window = this.currentSession ? ` -t ${this.currentSession}:${this.currentWindow}` : '';
       const { stdout } = await execAsync(
-        `tmux list-panes -F '#{pane_index}:#{pane_width}x#{pane_height}:#{pane_current_path}:#{pane_active}:#{pane_title}'`
+        `${this.bin} list-panes${window} -F '#{pane_index}:#{pane_width}x#{pane_height}:#{pane_current_path}:#{pane_active}:#{pane_title}'`
       );
       
       return stdout.trim().split('\n').map(line => {
@@ -65,6 +139,48 @@ export class TmuxManager {
     }
   }
 
+  /**
+   * List every pane on the tmux server, grouped into sessions and windows,
+   * with the command and PID running in each
+   */
+  async listAllPanes() {
+    try {
+      const { stdout } = await execAsync(
+        `${this.bin} list-panes -a -F '#{session_name}\t#{window_index}\t#{window_name}\t#{pane_index}\t#{pane_id}\t#{pane_pid}\t#{pane_current_command}\t#{pane_current_path}\t#{pane_active}\t#{pane_title}'`
+      );
+
+      const sessions = new Map();
+      for (const line of stdout.trim().split('\n').filter(Boolean)) {
+        const [session, windowIndex, windowName, index, id, pid, command, path, active, title] = line.split('\t');
+
+        if (!sessions.has(session)) {
+          sessions.set(session, { name: session, windows: new Map() });
+        }
+        const windows = sessions.get(session).windows;
+        if (!windows.has(windowIndex)) {
+          windows.set(windowIndex, { index: parseInt(windowIndex), name: windowName, panes: [] });
+        }
+
+        windows.get(windowIndex).panes.push({
+          index: parseInt(index),
+          id,
+          pid: parseInt(pid),
+          command,
+          path,
+          active: active === '1',
+          title: title || ''
+        });
+      }
+
+      return [...sessions.values()].map(session => ({
+        name: session.name,
+        windows: [...session.windows.values()]
+      }));
+    } catch (error) {
+      throw new Error(`Failed to list tmux sessions: ${error.message}`);
+    }
+  }
+
   /**
    * Discover Claude Terminal (CT Pane) using intelligent detection
    */
@@ -118,14 +234,14 @@ export class TmuxManager {
     try {
       // Split window horizontally (create right pane)
       const { stdout } = await execAsync(
-        `tmux split-window -h -t ${this.currentSession}:${this.currentWindow} -P -F '#{pane_index}'`
+        `${this.bin} split-window -h -t ${this.currentSession}:${this.currentWindow} -P -F '#{pane_index}'`
       );
       
       const newPaneIndex = parseInt(stdout.trim());
       this.ctPane = newPaneIndex;
       
       // Set pane title
-      await execAsync(`tmux select-pane -t ${this.currentSession}:${this.currentWindow}.${newPaneIndex} -T "Claude Terminal"`);
+      await execAsync(`${this.bin} select-pane -t ${this.currentSession}:${this.currentWindow}.${newPaneIndex} -T "Claude Terminal"`);
       
       // Sync directory to current working directory
       await this.syncDirectory();
@@ -156,9 +272,19 @@ export class TmuxManager {
   }
 
   /**
-   * Send keys to target pane
+   * Every send-keys goes through here so the keys are journaled before tmux
+   * types them. `keys` is what gets journaled; `args` the shell-ready arguments.
    */
-  async sendKeys(command, pressEnter = false, targetPane = null) {
+  async execSendKeys(target, keys, args) {
+    this.journal.record(target, keys);
+    return execAsync(`${this.bin} send-keys -t ${target} ${args}`);
+  }
+
+  /**
+   * Send keys to target pane. `journaled` replaces the text in the keys journal
+   * (e.g. for ephemeral commands that must not be written anywhere).
+   */
+  async sendKeys(command, pressEnter = false, targetPane = null, journaled = command) {
     const paneIndex = targetPane || this.ctPane;
     if (!paneIndex) {
       throw new Error('No target pane specified and no default Claude Terminal pane available');
@@ -168,69 +294,359 @@ export class TmuxManager {
     const enterKey = pressEnter ? ' C-m' : '';
     
     try {
-      await execAsync(`tmux send-keys -t ${target} '${command.replace(/'/g, "'\"'\"'")}' ${enterKey}`);
+      await this.execSendKeys(target, pressEnter ? [journaled, 'C-m'] : [journaled], `'${command.replace(/'/g, "'\"'\"'")}' ${enterKey}`);
     } catch (error) {
       throw new Error(`Failed to send keys to pane ${paneIndex}: ${error.message}`);
     }
   }
 
   /**
-   * Capture pane content
+   * Send raw keys (tmux key names like Up, C-c, Escape) or literal text to a pane,
+   * without clearing the pane or pressing Enter
    */
-  async capturePane() {
-    if (!this.ctPane) {
+  async sendRawKeys(keys, literal = false, targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
+      throw new Error('No target pane specified and no default Claude Terminal pane available');
+    }
+
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+    const keyList = Array.isArray(keys) ? keys : [keys];
+    const literalFlag = literal ? '-l ' : '';
+    const args = keyList.map(key => this.shellQuote(key)).join(' ');
+
+    try {
+      await this.execSendKeys(target, keyList, `${literalFlag}${args}`);
+    } catch (error) {
+      throw new Error(`Failed to send keys to pane ${paneIndex}: ${error.message}`);
+    }
+  }
+
+  /**
+   * Quote a single argument for the shell
+   */
+  shellQuote(value) {
+    return `'${String(value).replace(/'/g, "'\"'\"'")}'`;
+  }
+
+  /**
+   * Capture pane content, optionally including the last `scrollbackLines` lines
+   * that have scrolled off the visible screen
+   */
+  async capturePane(targetPane = null, scrollbackLines = 0, { ansi = false } = {}) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
       throw new Error('No Claude Terminal pane available');
     }
     
-    const target = `${this.currentSession}:${this.currentWindow}.${this.ctPane}`;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
     
     try {
-      const { stdout } = await execAsync(`tmux capture-pane -t ${target} -p`);
-      return this.cleanOutput(stdout);
+      // -J joins lines tmux wrapped at the pane width, so long paths and JSON stay intact
+      const startFlag = scrollbackLines > 0 ? ` -S -${scrollbackLines}` : '';
+      if (ansi) {
+        // -e keeps color/formatting sequences; only line endings are tidied
+        const { stdout } = await execAsync(`${this.bin} capture-pane -t ${target} -p -J -e${startFlag}`, { maxBuffer: CAPTURE_MAX_BUFFER });
+        return stdout.replace(CARRIAGE_RETURN, '').replace(TRAILING_WHITESPACE, '').trim();
+      }
+      const { stdout } = await execAsync(`${this.bin} capture-pane -t ${target} -p -J${startFlag}`, { maxBuffer: CAPTURE_MAX_BUFFER });
+      return this.cleanCached(target, stdout);
     } catch (error) {
       throw new Error(`Failed to capture pane: ${error.message}`);
     }
   }
 
+  /**
+   * cleanOutput, skipped when the pane's raw capture hasn't changed since the
+   * last poll (long-running builds are polled far more often than they print)
+   */
+  cleanCached(target, raw) {
+    const last = this.lastCaptures.get(target);
+    if (last && last.raw === raw) {
+      return last.clean;
+    }
+    const clean = this.cleanOutput(raw);
+    this.lastCaptures.delete(target);
+    this.lastCaptures.set(target, { raw, clean });
+    if (this.lastCaptures.size > MAX_CACHED_CAPTURES) {
+      this.lastCaptures.delete(this.lastCaptures.keys().next().value);
+    }
+    return clean;
+  }
+
   /**
    * Clean tmux output (remove ANSI escape sequences, etc.)
    */
   cleanOutput(output) {
     return output
-      // Remove all ANSI escape sequences (more comprehensive)
-      .replace(/\x1b\[[0-9;]*[a-zA-Z]/g, '')
-      .replace(/\x1b\[[\?\!0-9;]*[a-zA-Z]/g, '')
-      .replace(/\x1b[)(0-9A-Za-z]/g, '')
-      .replace(/\x1b[\[\]()#;?]*[0-9A-Fa-f]*/g, '')
+      // OSC strings: window titles, OSC 8 hyperlinks (the link text is kept), cwd reports
+      .replace(OSC_SEQUENCE, '')
+      // DCS/SOS/PM/APC strings (e.g. tmux passthrough, terminal queries)
+      .replace(STRING_SEQUENCE, '')
+      // CSI: SGR colors, cursor movement, erase, private modes, bracketed paste markers
+      .replace(CSI_SEQUENCE, '')
+      // Remaining two-character escapes (charset selection, keypad modes, save/restore cursor)
+      .replace(SHORT_ESCAPE, '')
       // Remove other control sequences but keep newlines and tabs
-      .replace(/[\x00-\x08\x0B-\x1f\x7f-\x9f]/g, '')
+      .replace(CONTROL_CHARS, '')
       // Remove carriage returns
-      .replace(/\r/g, '')
-      // Trim excessive whitespace but preserve structure
+      .replace(CARRIAGE_RETURN, '')
+      // Trim trailing whitespace per line in one pass instead of split/map/join
+      .replace(TRAILING_WHITESPACE, '')
+      .trim();
+  }
+
+  /**
+   * Wrap a command so it echoes a unique completion marker with its exit code.
+   * The command sits in its own `{ ...\n}` group so a trailing `&` or
+   * `# comment` can't break or swallow the marker.
+   */
+  wrapWithSentinel(command, sentinelId) {
+    return `{ ${command}\n}; echo __BRIDGE_DONE_${sentinelId}_$?`;
+  }
+
+  /**
+   * Find the echo of `typed` (prompt line plus continuation lines) in captured
+   * output and show it as `command` after the same prompt, so wrappers don't
+   * leak into results. `commandOutput` is only what was printed after the
+   * echo. When the echo isn't on screen both are returned unchanged.
+   */
+  replaceCommandEcho(output, typed, command = typed) {
+    const lines = output.split('\n');
+    const typedLines = typed.split('\n').map(line => line.trimEnd());
+    const start = lines.findIndex(line => line.includes(typedLines[0]));
+    const end = start + typedLines.length - 1;
+    if (start === -1 || end >= lines.length || !lines[end].includes(typedLines[typedLines.length - 1])) {
+      return { output, commandOutput: output };
+    }
+
+    const prompt = lines[start].slice(0, lines[start].indexOf(typedLines[0]));
+    const after = lines.slice(end + 1);
+    return {
+      output: [...lines.slice(0, start), prompt + command, ...after].join('\n'),
+      commandOutput: after.join('\n')
+    };
+  }
+
+  /**
+   * Look for the completion marker in captured output.
+   * The echoed command line contains the literal `_$?`, so only the expanded
+   * marker (with digits) counts as completion; a leftover echo of the wrapper
+   * is dropped. In ANSI captures tmux may put SGR codes in front of the
+   * marker; those move to the previous line so colors still reset.
+   */
+  parseSentinel(output, sentinelId) {
+    const marker = new RegExp(`^((?:\x1b\\[[0-9;]*m)*)__BRIDGE_DONE_${sentinelId}_(\\d+)\\s*$`, 'm');
+    const match = output.match(marker);
+
+    const lines = [];
+    for (const line of output.split('\n')) {
+      const lineMatch = line.match(marker);
+      if (line.includes(`echo __BRIDGE_DONE_${sentinelId}_$?`)) {
+        continue;
+      } else if (!lineMatch) {
+        lines.push(line);
+      } else if (lineMatch[1] && lines.length > 0) {
+        lines[lines.length - 1] += lineMatch[1];
+      }
+    }
+    const cleaned = lines.join('\n').trim();
+
+    return {
+      found: match !== null,
+      exitCode: match ? parseInt(match[2]) : null,
+      output: cleaned
+    };
+  }
+
+  /**
+   * Wrap a command so the shell dumps its environment before and after it runs.
+   * The exit status is preserved so a sentinel appended afterwards still sees it,
+   * and the command is grouped on its own line like in wrapWithSentinel.
+   */
+  wrapWithEnvSnapshot(command, snapshotBase) {
+    return `env > ${snapshotBase}.before; { ${command}\n}; __bridge_rc=$?; env > ${snapshotBase}.after; (exit $__bridge_rc)`;
+  }
+
+  /**
+   * Remove the env snapshot wrapper from the echoed command lines
+   */
+  stripEnvSnapshot(output, snapshotBase) {
+    return output
       .split('\n')
-      .map(line => line.trimRight())
+      .filter(line => !line.includes(`}; __bridge_rc=$?; env > ${snapshotBase}.after; (exit $__bridge_rc)`))
       .join('\n')
-      .trim();
+      .split(`env > ${snapshotBase}.before; { `).join('');
+  }
+
+  /**
+   * Parse `env` output into a map; lines without NAME= continue the previous value
+   */
+  parseEnv(text) {
+    const env = new Map();
+    let lastKey = null;
+
+    for (const line of text.split('\n')) {
+      const match = line.match(/^([A-Za-z_][A-Za-z0-9_]*)=(.*)$/);
+      if (match) {
+        lastKey = match[1];
+        env.set(lastKey, match[2]);
+      } else if (lastKey && line !== '') {
+        env.set(lastKey, env.get(lastKey) + '\n' + line);
+      }
+    }
+
+    return env;
+  }
+
+  /**
+   * Report variables added, changed or removed between two environments
+   */
+  diffEnvironment(before, after) {
+    const ignored = new Set(['_', 'OLDPWD', 'SHLVL']);
+    const diff = { added: {}, changed: {}, removed: [] };
+
+    for (const [key, value] of after) {
+      if (ignored.has(key)) continue;
+      if (!before.has(key)) {
+        diff.added[key] = value;
+      } else if (before.get(key) !== value) {
+        diff.changed[key] = { from: before.get(key), to: value };
+      }
+    }
+
+    for (const key of before.keys()) {
+      if (!ignored.has(key) && !after.has(key)) {
+        diff.removed.push(key);
+      }
+    }
+
+    return diff;
+  }
+
+  /**
+   * Read and diff the snapshot files written by wrapWithEnvSnapshot, then remove them
+   */
+  async readEnvSnapshotDiff(snapshotBase) {
+    try {
+      // The after-snapshot is written just after the command exits, so allow it a moment
+      for (let attempt = 0; attempt < 3; attempt++) {
+        try {
+          const [before, after] = await Promise.all([
+            readFile(`${snapshotBase}.before`, 'utf-8'),
+            readFile(`${snapshotBase}.after`, 'utf-8')
+          ]);
+          return this.diffEnvironment(this.parseEnv(before), this.parseEnv(after));
+        } catch (error) {
+          await new Promise(resolve => setTimeout(resolve, 200));
+        }
+      }
+      return null;
+    } finally {
+      await Promise.all([
+        unlink(`${snapshotBase}.before`).catch(() => {}),
+        unlink(`${snapshotBase}.after`).catch(() => {})
+      ]);
+    }
+  }
+
+  /**
+   * Format an environment diff for display
+   */
+  formatEnvironmentDiff(diff) {
+    if (!diff) {
+      return 'Environment snapshot unavailable';
+    }
+
+    const lines = [
+      ...Object.entries(diff.added).map(([key, value]) => `+ ${key}=${value}`),
+      ...Object.entries(diff.changed).map(([key, { from, to }]) => `~ ${key}: ${from} -> ${to}`),
+      ...diff.removed.map(key => `- ${key}`)
+    ];
+
+    return lines.length > 0 ? lines.join('\n') : 'No environment changes';
   }
 
   /**
    * Get the process ID of the shell running in the CT Pane
    */
-  async getShellPid() {
-    if (!this.ctPane) {
+  async getShellPid(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
       throw new Error('No Claude Terminal pane available');
     }
     
-    const target = `${this.currentSession}:${this.currentWindow}.${this.ctPane}`;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
     
     try {
-      const { stdout } = await execAsync(`tmux display-message -t ${target} -p '#{pane_pid}'`);
+      const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{pane_pid}'`);
       return parseInt(stdout.trim());
     } catch (error) {
       throw new Error(`Failed to get shell PID: ${error.message}`);
     }
   }
 
+  /**
+   * Name of the shell the pane was started with (its root process)
+   */
+  async getPaneShell(targetPane = null) {
+    const shellPid = await this.getShellPid(targetPane);
+    try {
+      const { stdout } = await execAsync(`ps -o comm= -p ${shellPid}`);
+      return path.basename(stdout.trim().replace(/^-/, ''));
+    } catch (error) {
+      throw new Error(`Failed to get pane shell: ${error.message}`);
+    }
+  }
+
+  /**
+   * Whether the completion sentinel (`; echo ..._$?`) works in this shell
+   */
+  supportsSentinel(shell) {
+    return ['sh', 'bash', 'zsh', 'dash', 'ksh', 'mksh', 'ash', 'busybox'].includes(shell);
+  }
+
+  /**
+   * Git branch checked out in the pane's working directory, or null outside a repo
+   */
+  async getPaneBranch(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+
+    try {
+      const { stdout: cwd } = await execAsync(`${this.bin} display-message -t ${target} -p '#{pane_current_path}'`);
+      const { stdout } = await execAsync(`git -C ${this.shellQuote(cwd.trim())} rev-parse --abbrev-ref HEAD`);
+      const branch = stdout.trim();
+      if (branch !== 'HEAD') {
+        return branch;
+      }
+      const { stdout: sha } = await execAsync(`git -C ${this.shellQuote(cwd.trim())} rev-parse --short HEAD`);
+      return `detached@${sha.trim()}`;
+    } catch (error) {
+      return null;
+    }
+  }
+
+  /**
+   * Get scrollback size and limit for a pane (used to estimate output rate)
+   */
+  async getHistoryInfo(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
+      throw new Error('No Claude Terminal pane available');
+    }
+
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+
+    try {
+      const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{history_size}:#{history_limit}'`);
+      const [historySize, historyLimit] = stdout.trim().split(':').map(value => parseInt(value));
+      return { historySize, historyLimit };
+    } catch (error) {
+      throw new Error(`Failed to get history info: ${error.message}`);
+    }
+  }
+
   /**
    * Get child processes of a given PID
    */
@@ -247,13 +663,13 @@ export class TmuxManager {
   /**
    * Check if pane is idle (no child processes running)
    */
-  async isPaneIdle() {
-    if (!this.ctPane) {
+  async isPaneIdle(targetPane = null) {
+    if (!targetPane && !this.ctPane) {
       throw new Error('No Claude Terminal pane available');
     }
     
     try {
-      const shellPid = await this.getShellPid();
+      const shellPid = await this.getShellPid(targetPane);
       const childProcesses = await this.getChildProcesses(shellPid);
       
       // Pane is idle if shell has no child processes
@@ -268,8 +684,8 @@ export class TmuxManager {
   /**
    * Check if command is complete using process monitoring (preferred method)
    */
-  async isCommandCompleteByProcess() {
-    return await this.isPaneIdle();
+  async isCommandCompleteByProcess(targetPane = null) {
+    return await this.isPaneIdle(targetPane);
   }
 
   /**
@@ -299,16 +715,16 @@ export class TmuxManager {
   /**
    * Primary command completion detection using process monitoring
    */
-  async isCommandComplete() {
+  async isCommandComplete(targetPane = null) {
     try {
       // Try process-based detection first (more reliable)
-      return await this.isCommandCompleteByProcess();
+      return await this.isCommandCompleteByProcess(targetPane);
     } catch (error) {
       console.error('Process-based detection failed, using output fallback:', error.message);
       
       // Fall back to output-based detection if process monitoring fails
       try {
-        const output = await this.capturePane();
+        const output = await this.capturePane(targetPane);
         return this.isCommandCompleteByOutput(output);
       } catch (fallbackError) {
         console.error('Both detection methods failed:', fallbackError.message);
@@ -317,19 +733,313 @@ export class TmuxManager {
     }
   }
 
+  // ===== PANE HANDOFF METHODS =====
+
   /**
-   * Switch focus to CT Pane
+   * Who controls the pane: 'agent' (default), 'requested' or 'human'.
+   * Stored as a tmux pane option so the human can flip it from tmux too.
    */
-  async focusClaudeTerminal() {
-    if (!this.ctPane) {
+  async getPaneControl(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
+      throw new Error('No target pane specified and no default Claude Terminal pane available');
+    }
+
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+
+    try {
+      const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{@claude_control}'`);
+      return stdout.trim() || 'agent';
+    } catch (error) {
+      throw new Error(`Failed to read pane control: ${error.message}`);
+    }
+  }
+
+  /**
+   * Set who controls the pane
+   */
+  async setPaneControl(control, targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
+      throw new Error('No target pane specified and no default Claude Terminal pane available');
+    }
+
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+
+    try {
+      await execAsync(`${this.bin} set-option -p -t ${target} @claude_control ${control}`);
+    } catch (error) {
+      throw new Error(`Failed to set pane control: ${error.message}`);
+    }
+  }
+
+  /**
+   * Ask the human (in their tmux client) to accept control of the pane.
+   * Answering y marks the pane human-controlled; anything else leaves it requested.
+   */
+  async promptHandoff(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+    const prompt = `Claude wants to hand you pane ${paneIndex}. Take control? (y/n)`;
+
+    try {
+      await execAsync(`${this.bin} confirm-before -p ${this.shellQuote(prompt)} ${this.shellQuote(`set-option -p -t ${target} @claude_control human`)}`);
+    } catch (error) {
+      throw new Error(`Failed to prompt for handoff: ${error.message}`);
+    }
+  }
+
+  /**
+   * Ask the human whether they are done with the pane. Only their y hands it
+   * back to the agent; the agent can't take it back on its own.
+   */
+  async promptRelease(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+    const prompt = `Claude wants pane ${paneIndex} back. Hand it back? (y/n)`;
+
+    try {
+      await execAsync(`${this.bin} confirm-before -p ${this.shellQuote(prompt)} ${this.shellQuote(`set-option -p -t ${target} @claude_control agent`)}`);
+    } catch (error) {
+      throw new Error(`Failed to prompt for release: ${error.message}`);
+    }
+  }
+
+  // ===== APPROVAL METHODS =====
+
+  /**
+   * Show the human a Run/Deny menu for a command and wait for the answer.
+   * Resolves to 'approved', 'denied' or 'timeout'; the choice is written to a
+   * per-request pane option that is polled and then removed.
+   */
+  async requestApproval(command, approvalId, timeoutMs, targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+    const option = `@claude_approval_${approvalId}`;
+    const shown = command.length > 60 ? `${command.slice(0, 57)}...` : command;
+    // -T is a format string, so literal #s must be doubled
+    const title = `Claude wants to run in pane ${paneIndex}: ${shown}`.replace(/#/g, '##');
+
+    try {
+      await execAsync(`${this.bin} display-menu -t ${target} -T ${this.shellQuote(title)} ` +
+        `Run y ${this.shellQuote(`set-option -p -t ${target} ${option} approved`)} ` +
+        `Deny n ${this.shellQuote(`set-option -p -t ${target} ${option} denied`)}`);
+    } catch (error) {
+      throw new Error(`Failed to show approval menu: ${error.message}`);
+    }
+
+    const deadline = Date.now() + timeoutMs;
+    try {
+      while (Date.now() < deadline) {
+        const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{${option}}'`);
+        const answer = stdout.trim();
+        if (answer === 'approved' || answer === 'denied') {
+          return answer;
+        }
+        await new Promise(resolve => setTimeout(resolve, 500));
+      }
+      return 'timeout';
+    } finally {
+      await execAsync(`${this.bin} set-option -p -u -t ${target} ${option}`).catch(() => {});
+    }
+  }
+
+  // ===== PANE MANAGEMENT METHODS =====
+
+  /**
+   * Run a layout change and follow the CT Pane through it: tmux renumbers
+   * pane indexes when panes are split or killed, but pane IDs are stable
+   */
+  async preservingCtPane(operation) {
+    const ctPaneId = this.ctPane ? await this.getPaneId(this.ctPane) : null;
+
+    const result = await operation();
+
+    if (ctPaneId) {
+      const { stdout } = await execAsync(`${this.bin} list-panes -t ${this.currentSession}:${this.currentWindow} -F '#{pane_id}:#{pane_index}'`);
+      const match = stdout.trim().split('\n').map(line => line.split(':')).find(([id]) => id === ctPaneId);
+      this.ctPane = match ? parseInt(match[1]) : null;
+    }
+
+    return result;
+  }
+
+  /**
+   * Split a pane; direction is 'horizontal' (side by side) or 'vertical' (stacked).
+   * Returns the new pane's index.
+   */
+  async splitPane(targetPane, direction = 'horizontal', { size = null } = {}) {
+    const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
+    const flags = [direction === 'vertical' ? '-v' : '-h'];
+    if (size) {
+      flags.push(`-l ${parseInt(size)}`);
+    }
+
+    try {
+      return await this.preservingCtPane(async () => {
+        const { stdout } = await execAsync(
+          `${this.bin} split-window ${flags.join(' ')} -t ${target} -c ${this.shellQuote(process.cwd())} -P -F '#{pane_index}'`
+        );
+        return parseInt(stdout.trim());
+      });
+    } catch (error) {
+      throw new Error(`Failed to split pane ${targetPane}: ${error.message}`);
+    }
+  }
+
+  /**
+   * Add a pane after the last one in the window without taking focus, so no
+   * existing pane is renumbered. Returns once its shell is ready, with its
+   * pane ID (e.g. "%12"), which works anywhere a pane index does.
+   */
+  async createFreshPane() {
+    const panes = await this.listPanes();
+    const lastPane = Math.max(...panes.map(pane => pane.index));
+    const target = `${this.currentSession}:${this.currentWindow}.${lastPane}`;
+
+    try {
+      const { stdout } = await execAsync(
+        `${this.bin} split-window -d -v -t ${target} -c ${this.shellQuote(process.cwd())} -P -F '#{pane_id}'`
+      );
+      const paneId = stdout.trim();
+
+      // Keys typed before the shell has started can be lost, so wait for its prompt
+      for (let attempt = 0; attempt < 50; attempt++) {
+        if (this.isCommandCompleteByOutput(await this.capturePane(paneId))) {
+          break;
+        }
+        await new Promise(resolve => setTimeout(resolve, 200));
+      }
+
+      return paneId;
+    } catch (error) {
+      throw new Error(`Failed to create fresh pane: ${error.message}`);
+    }
+  }
+
+  /**
+   * Kill a pane and the processes in it
+   */
+  async killPane(targetPane) {
+    const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
+
+    try {
+      await this.preservingCtPane(() => execAsync(`${this.bin} kill-pane -t ${target}`));
+    } catch (error) {
+      throw new Error(`Failed to kill pane ${targetPane}: ${error.message}`);
+    }
+    this.lastCaptures.delete(target);
+  }
+
+  /**
+   * Resize a pane to an absolute width and/or height in cells
+   */
+  async resizePane(targetPane, { width = null, height = null } = {}) {
+    const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
+    const flags = [];
+    if (width) {
+      flags.push(`-x ${parseInt(width)}`);
+    }
+    if (height) {
+      flags.push(`-y ${parseInt(height)}`);
+    }
+
+    try {
+      await execAsync(`${this.bin} resize-pane -t ${target} ${flags.join(' ')}`);
+    } catch (error) {
+      throw new Error(`Failed to resize pane ${targetPane}: ${error.message}`);
+    }
+  }
+
+  /**
+   * Stable tmux ID (e.g. "%3") of the pane a target currently resolves to
+   */
+  async getPaneId(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+
+    try {
+      const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{pane_id}'`);
+      return stdout.trim();
+    } catch (error) {
+      throw new Error(`Failed to get pane ID: ${error.message}`);
+    }
+  }
+
+  /**
+   * Current index of a pane, which may have been named by its ID
+   */
+  async getPaneIndex(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+
+    try {
+      const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{pane_index}'`);
+      return parseInt(stdout.trim());
+    } catch (error) {
+      throw new Error(`Failed to get pane index: ${error.message}`);
+    }
+  }
+
+  /**
+   * Current width of a pane in columns
+   */
+  async getPaneWidth(targetPane) {
+    const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
+
+    try {
+      const { stdout } = await execAsync(`${this.bin} display-message -t ${target} -p '#{pane_width}'`);
+      return parseInt(stdout.trim());
+    } catch (error) {
+      throw new Error(`Failed to get width of pane ${targetPane}: ${error.message}`);
+    }
+  }
+
+  /**
+   * Restart the pane's shell, killing whatever is running in it
+   */
+  async respawnPane(targetPane) {
+    const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
+
+    try {
+      await execAsync(`${this.bin} respawn-pane -k -t ${target} -c ${this.shellQuote(process.cwd())}`);
+    } catch (error) {
+      throw new Error(`Failed to respawn pane ${targetPane}: ${error.message}`);
+    }
+  }
+
+  /**
+   * Indexes of panes in the current window tagged with a group
+   * (`tmux set-option -p @claude_group <name>`)
+   */
+  async getGroupPanes(group) {
+    try {
+      const { stdout } = await execAsync(
+        `${this.bin} list-panes -t ${this.currentSession}:${this.currentWindow} -F '#{pane_index}:#{@claude_group}'`
+      );
+      return stdout.trim().split('\n')
+        .map(line => line.split(':'))
+        .filter(([, paneGroup]) => paneGroup === group)
+        .map(([index]) => parseInt(index));
+    } catch (error) {
+      throw new Error(`Failed to list pane group ${group}: ${error.message}`);
+    }
+  }
+
+  /**
+   * Switch focus to CT Pane, or to the pane a command is running in
+   */
+  async focusClaudeTerminal(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
       throw new Error('No Claude Terminal pane available');
     }
     
-    const target = `${this.currentSession}:${this.currentWindow}.${this.ctPane}`;
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
     
     try {
-      await execAsync(`tmux select-pane -t ${target}`);
-      return { success: true, message: `Switched focus to Claude Terminal (pane ${this.ctPane})` };
+      await execAsync(`${this.bin} select-pane -t ${target}`);
+      return { success: true, message: `Switched focus to ${String(paneIndex) === String(this.ctPane) ? `Claude Terminal (pane ${paneIndex})` : `pane ${paneIndex}`}` };
     } catch (error) {
       throw new Error(`Failed to focus Claude Terminal: ${error.message}`);
     }
@@ -346,12 +1056,32 @@ export class TmuxManager {
     
     // Send Ctrl+C to interrupt any running command, then clear
     await this.sendKeys('', false, paneIndex); // Just to ensure pane is active
-    await execAsync(`tmux send-keys -t ${this.currentSession}:${this.currentWindow}.${paneIndex} C-c C-l`);
+    // Separate sends: readline drops the next typed character when C-l arrives mid C-c redraw
+    const target = `${this.currentSession}:${this.currentWindow}.${paneIndex}`;
+    await this.execSendKeys(target, ['C-c'], 'C-c');
+    await new Promise(resolve => setTimeout(resolve, 100));
+    await this.execSendKeys(target, ['C-l'], 'C-l');
     
     // Wait for clear to take effect
     await new Promise(resolve => setTimeout(resolve, 200));
   }
 
+  /**
+   * Interrupt whatever is running in the target pane (Ctrl+C)
+   */
+  async sendInterrupt(targetPane = null) {
+    const paneIndex = targetPane || this.ctPane;
+    if (!paneIndex) {
+      throw new Error('No target pane specified and no default Claude Terminal pane available');
+    }
+
+    try {
+      await this.execSendKeys(`${this.currentSession}:${this.currentWindow}.${paneIndex}`, ['C-c'], 'C-c');
+    } catch (error) {
+      throw new Error(`Failed to interrupt pane ${paneIndex}: ${error.message}`);
+    }
+  }
+
   /**
    * Get status of CT Pane and tmux environment
    */
@@ -418,7 +1148,7 @@ export class TmuxManager {
     
     try {
       // Capture terminal history
-      const { stdout } = await execAsync(`tmux capture-pane -t ${target} -p -S -${lines}`);
+      const { stdout } = await execAsync(`${this.bin} capture-pane -t ${target} -p -J -S -${lines}`);
       const cleanOutput = this.cleanOutput(stdout);
       
       // Just return the cleaned lines - let the LLM parse them
@@ -441,7 +1171,7 @@ export class TmuxManager {
       const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
       
       // Capture recent terminal lines to extract commands and their results
-      const { stdout } = await execAsync(`tmux capture-pane -t ${target} -p -S -50`);
+      const { stdout } = await execAsync(`${this.bin} capture-pane -t ${target} -p -J -S -50`);
       const lines = stdout.split('\n').filter(line => line.trim());
       
       // Parse commands and their results
@@ -584,8 +1314,8 @@ export class TmuxManager {
     try {
       // Get pager detection info using tmux format variables
       const [currentCommand, alternateOn] = await Promise.all([
-        execAsync(`tmux display-message -t ${target} -p '#{pane_current_command}'`),
-        execAsync(`tmux display-message -t ${target} -p '#{alternate_on}'`)
+        execAsync(`${this.bin} display-message -t ${target} -p '#{pane_current_command}'`),
+        execAsync(`${this.bin} display-message -t ${target} -p '#{alternate_on}'`)
       ]);
 
       const command = currentCommand.stdout.trim();
@@ -652,7 +1382,7 @@ export class TmuxManager {
     const target = `${this.currentSession}:${this.currentWindow}.${targetPane}`;
     
     try {
-      await execAsync(`tmux send-keys -t ${target} '${keys}'`);
+      await this.execSendKeys(target, [keys], `'${keys}'`);
       return {
         success: true,
         keys: keys,
@@ -0,0 +1,49 @@
+/**
+ * Usage Stats - Summarize the command history: volume per day, outcomes, busiest panes,
+ * most frequent commands and latency
+ */
+
+/**
+ * `entries` as returned by HistoryStore.read(). Interactive programs are counted
+ * per day and per pane but left out of the outcome and latency figures, since
+ * they were handed to the user rather than run to completion.
+ */
+export function summarizeUsage(entries, { top = 5 } = {}) {
+  const perDay = new Map();
+  const perPane = new Map();
+  const perCommand = new Map();
+  const count = (map, key) => map.set(key, (map.get(key) || 0) + 1);
+
+  let finished = 0;
+  let succeeded = 0;
+  let timedOut = 0;
+  let totalDuration = 0;
+
+  for (const entry of entries) {
+    count(perDay, entry.started_at.slice(0, 10));
+    count(perPane, String(entry.pane));
+    count(perCommand, entry.command);
+    if (entry.status === 'interactive') {
+      continue;
+    }
+
+    finished++;
+    if (entry.status === 'completed') succeeded++;
+    if (entry.timed_out) timedOut++;
+    totalDuration += entry.duration_ms || 0;
+  }
+
+  const ranked = map => [...map.entries()]
+    .sort((a, b) => b[1] - a[1])
+    .slice(0, top);
+
+  return {
+    total: entries.length,
+    days: [...perDay.entries()].sort(([a], [b]) => a.localeCompare(b)).map(([date, commands]) => ({ date, commands })),
+    successRate: finished ? succeeded / finished : null,
+    timeoutRate: finished ? timedOut / finished : null,
+    averageDurationMs: finished ? Math.round(totalDuration / finished) : null,
+    busiestPanes: ranked(perPane).map(([pane, commands]) => ({ pane, commands })),
+    topCommands: ranked(perCommand).map(([command, runs]) => ({ command, runs }))
+  };
+}
@@ -0,0 +1,86 @@
+/**
+ * Workspace Tree - Builds JSON file trees sandboxed to configured roots
+ */
+import { readdir, realpath, stat } from 'fs/promises';
+import path from 'path';
+
+export class WorkspaceTree {
+  constructor(roots = null, maxEntries = 2000) {
+    const configured = roots || process.env.TMUX_MCP_FS_ROOTS?.split(':').filter(Boolean);
+    this.roots = (configured && configured.length > 0 ? configured : [process.cwd()])
+      .map(root => path.resolve(root));
+    this.maxEntries = maxEntries;
+  }
+
+  /**
+   * Resolve a requested path and make sure it stays inside one of the roots.
+   * Symlinks are resolved first so they can't be used to escape the sandbox.
+   */
+  async resolvePath(requestedPath = null) {
+    const candidate = path.resolve(this.roots[0], requestedPath || '.');
+    const resolved = await realpath(candidate);
+
+    for (const root of this.roots) {
+      const realRoot = await realpath(root).catch(() => root);
+      if (resolved === realRoot || resolved.startsWith(realRoot + path.sep)) {
+        return resolved;
+      }
+    }
+
+    throw new Error(`Path ${requestedPath} is outside the allowed roots (${this.roots.join(', ')})`);
+  }
+
+  /**
+   * Build a file tree rooted at the given path, up to `depth` levels deep
+   */
+  async buildTree(requestedPath = null, depth = 2) {
+    const rootPath = await this.resolvePath(requestedPath);
+    const state = { entries: 0, truncated: false };
+    const tree = await this.buildNode(rootPath, depth, state);
+
+    return { tree, entries: state.entries, truncated: state.truncated };
+  }
+
+  async buildNode(nodePath, depth, state) {
+    const info = await stat(nodePath);
+    state.entries++;
+
+    const node = {
+      name: path.basename(nodePath),
+      path: nodePath,
+      type: info.isDirectory() ? 'directory' : 'file',
+      size: info.size,
+      mtime: info.mtime.toISOString()
+    };
+
+    if (!info.isDirectory() || depth <= 0) {
+      return node;
+    }
+
+    const dirents = await readdir(nodePath, { withFileTypes: true });
+    dirents.sort((a, b) => a.name.localeCompare(b.name));
+
+    node.children = [];
+    for (const dirent of dirents) {
+      if (state.entries >= this.maxEntries) {
+        state.truncated = true;
+        break;
+      }
+
+      // Don't follow symlinks out of the tree; report them as plain entries
+      if (dirent.isSymbolicLink()) {
+        state.entries++;
+        node.children.push({ name: dirent.name, path: path.join(nodePath, dirent.name), type: 'symlink' });
+        continue;
+      }
+
+      try {
+        node.children.push(await this.buildNode(path.join(nodePath, dirent.name), depth - 1, state));
+      } catch (error) {
+        // Entry vanished or is unreadable; skip it rather than failing the tree
+      }
+    }
+
+    return node;
+  }
+}